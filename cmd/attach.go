@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/AntoineToussaint/kommence/pkg/control"
+	"github.com/AntoineToussaint/kommence/pkg/output"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// detachKey is Ctrl-] like telnet, for tasks running in a terminal
+const detachKey = 0x1d
+
+// attachCmd represents the attach command
+var attachCmd = &cobra.Command{
+	Use:   "attach <task>",
+	Short: "Send keyboard input to a task of the running session",
+	Long: `Send keyboard input to a task of the running session and show its output,
+while the output of the other tasks is paused in the session.
+Detach with Ctrl-D, or Ctrl-] for tasks running in a terminal.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		log := output.NewLogger(debug)
+		socket, err := control.Socket(kommenceDir)
+		if err != nil {
+			log.Errorf(err.Error()+"\n", color.FgRed, color.Bold)
+			os.Exit(1)
+		}
+		a, err := control.NewClient(socket).Attach(args[0])
+		if err != nil {
+			log.Errorf(err.Error()+"\n", color.FgRed, color.Bold)
+			os.Exit(1)
+		}
+		defer a.Close()
+
+		// Keystrokes go straight to terminals, other tasks get lines
+		raw := a.TTY && term.IsTerminal(int(os.Stdin.Fd()))
+		if raw {
+			log.Printf("attached to %v, press Ctrl-] to detach\n", args[0], color.Bold)
+			state, err := term.MakeRaw(int(os.Stdin.Fd()))
+			if err != nil {
+				log.Errorf("can't read keystrokes: %v\n", err, color.FgRed, color.Bold)
+				os.Exit(1)
+			}
+			defer term.Restore(int(os.Stdin.Fd()), state)
+		} else {
+			log.Printf("attached to %v, press Ctrl-D to detach\n", args[0], color.Bold)
+		}
+		go forwardInput(a, raw)
+
+		newline := "\n"
+		if raw {
+			newline = "\r\n"
+		}
+		for {
+			line, err := a.ReadLine()
+			if err != nil {
+				break
+			}
+			fmt.Print(line + newline)
+		}
+	},
+}
+
+// forwardInput sends stdin to the task until the detach key or the end of stdin.
+func forwardInput(a *control.Attachment, raw bool) {
+	defer a.Detach()
+	data := make([]byte, 1024)
+	for {
+		n, err := os.Stdin.Read(data)
+		if raw {
+			if i := bytes.IndexByte(data[:n], detachKey); i >= 0 {
+				_, _ = a.Write(data[:i])
+				return
+			}
+		}
+		if n > 0 {
+			if _, err := a.Write(data[:n]); err != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(attachCmd)
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/AntoineToussaint/kommence/pkg/configuration"
+	"github.com/AntoineToussaint/kommence/pkg/output"
+	"github.com/AntoineToussaint/kommence/pkg/runner"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+// execCmd represents the exec command
+var execCmd = &cobra.Command{
+	Use:   "exec <pod> [-- command...]",
+	Short: "Run a command, or a shell, in the container of a pod",
+	Long: `Run a command in the pod and container of a pod configuration, selected like for its forward,
+attached to the terminal. A shell runs by default and kommence exits with the exit code of the command.
+In a running session, press e to open a shell in one of its pods.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		log := output.NewLogger(debug)
+		config, err := configuration.Load(log, kommenceDir)
+		if err != nil {
+			log.Errorf(err.Error()+"\n", color.FgRed, color.Bold)
+			os.Exit(1)
+		}
+		c, ok := config.Pods.Get(args[0])
+		if !ok {
+			log.Errorf("unknown pod %v\n", args[0], color.FgRed, color.Bold)
+			os.Exit(1)
+		}
+		kube, err := runner.NewKubeLoader(kubeConfigPath, kubeContext).Load(c.Context)
+		if err != nil {
+			log.Errorf(err.Error()+"\n", color.FgRed, color.Bold)
+			os.Exit(1)
+		}
+		streams, restore, err := runner.TerminalStreams(os.Stdin)
+		if err != nil {
+			log.Errorf(err.Error()+"\n", color.FgRed, color.Bold)
+			os.Exit(1)
+		}
+		err = runner.Exec(context.Background(), kube, c, args[1:], streams)
+		restore()
+		os.Exit(exitCode(log, err))
+	},
+}
+
+// exitCode of a command run in a pod, 1 if it couldn't run.
+func exitCode(log *output.Logger, err error) int {
+	if err == nil {
+		return 0
+	}
+	var exit utilexec.ExitError
+	if errors.As(err, &exit) && exit.Exited() {
+		return exit.ExitStatus()
+	}
+	log.Errorf(err.Error()+"\n", color.FgRed, color.Bold)
+	return 1
+}
+
+func init() {
+	rootCmd.AddCommand(execCmd)
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"context"
+	"os"
+
+	"github.com/AntoineToussaint/kommence/pkg/output"
+	"github.com/AntoineToussaint/kommence/pkg/runner"
+	"github.com/fatih/color"
+	"golang.org/x/sys/unix"
+	"golang.org/x/term"
+)
+
+// execKey opens a shell in a pod of the session
+const execKey = 'e'
+
+// hotkeys reads the keys typed in the session until it stops, and returns a function to restore the terminal.
+// Keys are read as they are typed, without echo, and Ctrl-C still stops kommence.
+func hotkeys(ctx context.Context, log *output.Logger, r *runner.Runner) func() {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return func() {}
+	}
+	state, err := cbreak(fd)
+	if err != nil {
+		log.Debugf("can't read keys: %v\n", err)
+		return func() {}
+	}
+	log.Printf("Press %c to open a shell in a pod\n", execKey, color.Bold)
+	// Only one goroutine reads the terminal: a shell gets the keys until it exits
+	keys := runner.ReadKeys(os.Stdin)
+	go func() {
+		for {
+			key, ok := keys.Next()
+			if !ok {
+				return
+			}
+			if key != execKey {
+				continue
+			}
+			pod, ok := choosePod(log, r, keys)
+			if !ok {
+				continue
+			}
+			input, stop := keys.Input()
+			// The exit code of the shell is not an error
+			_ = exitCode(log, r.Exec(ctx, pod, nil, input))
+			stop()
+		}
+	}()
+	return func() {
+		_ = unix.IoctlSetTermios(fd, ioctlSetTermios, state)
+	}
+}
+
+// choosePod to exec into: the only pod of the session, or the one whose number is typed.
+func choosePod(log *output.Logger, r *runner.Runner, keys *runner.Keys) (string, bool) {
+	pods := r.Pods()
+	switch len(pods) {
+	case 0:
+		log.Printf("No pod in the session\n", color.Bold)
+		return "", false
+	case 1:
+		return pods[0], true
+	}
+	for i, pod := range pods {
+		if i < 9 {
+			log.Printf("%d) %v\n", i+1, pod, color.Bold)
+		}
+	}
+	log.Printf("Open a shell in: ", color.Bold)
+	key, ok := keys.Next()
+	if !ok {
+		return "", false
+	}
+	log.Printf("\n")
+	i := int(key - '1')
+	if i < 0 || i >= len(pods) || i >= 9 {
+		return "", false
+	}
+	return pods[i], true
+}
+
+// cbreak puts the terminal in cbreak mode: input is read key by key without echo, signals are still sent.
+func cbreak(fd int) (*unix.Termios, error) {
+	state, err := unix.IoctlGetTermios(fd, ioctlGetTermios)
+	if err != nil {
+		return nil, err
+	}
+	t := *state
+	t.Lflag &^= unix.ICANON | unix.ECHO
+	t.Cc[unix.VMIN] = 1
+	t.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, ioctlSetTermios, &t); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
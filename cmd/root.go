@@ -10,7 +10,9 @@ import (
 
 var kommenceDir string
 var kubeConfigPath string
+var kubeContext string
 var debug bool
+var noColor bool
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
@@ -24,6 +26,11 @@ var rootCmd = &cobra.Command{
 		log.Printf("To get started, run: ")
 		log.Printf(" kommence init\n", color.Bold)
 	},
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if noColor {
+			output.DisableColors()
+		}
+	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -42,8 +49,10 @@ func init() {
 	// will be global for your application.
 
 	rootCmd.PersistentFlags().StringVar(&kommenceDir, "config", "kommence", "kommence folder (default is kommence")
-	rootCmd.PersistentFlags().StringVar(&kubeConfigPath, "kube", "", "kubernetes config path")
+	rootCmd.PersistentFlags().StringVar(&kubeConfigPath, "kube", "", "kubernetes config path (default is KUBECONFIG or ~/.kube/config)")
+	rootCmd.PersistentFlags().StringVar(&kubeContext, "context", "", "kubernetes context (default is the current context)")
 	rootCmd.PersistentFlags().BoolVarP(&debug, "debug", "d", false, "debug mode")
+	rootCmd.PersistentFlags().BoolVar(&noColor, "no-color", false, "disable colors (NO_COLOR is also respected)")
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
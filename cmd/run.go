@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/AntoineToussaint/kommence/pkg/configuration"
+	"github.com/AntoineToussaint/kommence/pkg/output"
+	"github.com/AntoineToussaint/kommence/pkg/runner"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var watchRun bool
+
+// runCmd represents the run command
+var runCmd = &cobra.Command{
+	Use:   "run <executable>",
+	Short: "Run one executable in the foreground and exit with its exit code",
+	Long: `Run one executable in the foreground, with its env, path, ports, hooks and build step.
+Stdin is attached and kommence exits with the exit code of the executable.
+With --watch, it restarts on changes and its output is rendered like with kommence start.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		log := output.NewLogger(debug)
+		config, err := configuration.Load(log, kommenceDir)
+		if err != nil {
+			log.Errorf(err.Error()+"\n", color.FgRed, color.Bold)
+			os.Exit(1)
+		}
+		c, ok := config.Execs.Get(args[0])
+		if !ok {
+			log.Errorf("unknown executable %v\n", args[0], color.FgRed, color.Bold)
+			os.Exit(1)
+		}
+		if watchRun {
+			cancel := make(chan os.Signal, 2)
+			signal.Notify(cancel, os.Interrupt, syscall.SIGTERM)
+			ctx, stop := context.WithCancel(context.Background())
+			serve(ctx, stop, cancel, log, runner.New(log, config), &runner.Runtime{Executables: []string{c.ID}})
+			return
+		}
+		code, err := runner.Foreground(context.Background(), log, config, c)
+		if err != nil {
+			log.Errorf(err.Error()+"\n", color.FgRed, color.Bold)
+			os.Exit(1)
+		}
+		os.Exit(code)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(runCmd)
+	runCmd.Flags().BoolVarP(&watchRun, "watch", "w", false, "Restart on changes and render the output like kommence start")
+}
@@ -1,13 +1,17 @@
 package cmd
 
 import (
+	"bufio"
 	"context"
 	"github.com/AntoineToussaint/kommence/pkg/configuration"
+	"github.com/AntoineToussaint/kommence/pkg/control"
 	"github.com/AntoineToussaint/kommence/pkg/output"
 	"github.com/AntoineToussaint/kommence/pkg/runner"
+	"github.com/AntoineToussaint/kommence/pkg/session"
 	"github.com/c-bata/go-prompt"
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 	"os"
 	"os/signal"
 	"strings"
@@ -49,34 +53,91 @@ var startCmd = &cobra.Command{
 			log.Printf("Please specify executables, pods or flows or run in interactive mode.\n")
 			os.Exit(0)
 		}
-		go func() {
-			log.Debugf("starting runner\n")
-			err := r.Run(ctx, c)
-			if err != nil {
-				log.Printf("Stopping kommence because of unrecoverable error\n")
-			}
-			// Stop if/when we are done
-			log.Debugf("stopping the context\n")
+		serve(ctx, stop, cancel, log, r, c)
+	},
+}
+
+// serve runs a session until the tasks fail or kommence is interrupted.
+func serve(ctx context.Context, stop context.CancelFunc, cancel chan os.Signal, log *output.Logger, r *runner.Runner, c *runner.Runtime) {
+	// Expose the session to kommence status
+	if socket, err := control.Socket(kommenceDir); err == nil {
+		server := control.NewServer(log, socket, r)
+		if err := server.Start(); err != nil {
+			log.Errorf("can't start control server: %v\n", err)
+		} else {
+			defer server.Stop()
+			// No other session runs this configuration: its state is ours
+			state := strings.TrimSuffix(socket, ".sock") + ".state"
+			cleanOrphans(log, state)
+			recorder := session.NewRecorder(state)
+			runner.Record(recorder)
+			defer recorder.Close()
+		}
+	}
+	if len(c.Pods) > 0 {
+		defer hotkeys(ctx, log, r)()
+	}
+	go func() {
+		log.Debugf("starting runner\n")
+		err := r.Run(ctx, c)
+		if err != nil {
+			log.Printf("Stopping kommence because of unrecoverable error\n")
+		}
+		// Stop if/when we are done
+		log.Debugf("stopping the context\n")
+		stop()
+	}()
+L:
+	for {
+		select {
+		case <-ctx.Done():
+			log.Debugf("Stopping kommence from context.\n", color.Bold)
 			stop()
-		}()
-	L:
-		for {
-			select {
-			case <-ctx.Done():
-				log.Debugf("Stopping kommence from context.\n", color.Bold)
-				stop()
-				break L
-
-			case <-cancel:
-				log.Debugf("Stopping kommence from Ctrl-C.\n", color.Bold)
-				stop()
-				break L
+			break L
+
+		case <-cancel:
+			log.Debugf("Stopping kommence from Ctrl-C.\n", color.Bold)
+			stop()
+			break L
 
-			}
 		}
-		log.Debugf("Stopping the runner\n")
-		r.Stop(ctx)
-	},
+	}
+	log.Debugf("Stopping the runner\n")
+	r.Stop(ctx)
+}
+
+// cleanOrphans offers to kill the processes of a previous session that didn't stop them, because kommence was killed.
+func cleanOrphans(log *output.Logger, state string) {
+	leftovers, err := session.Leftovers(state)
+	if err != nil {
+		log.Errorf("can't check for processes of the previous session: %v\n", err)
+		return
+	}
+	if len(leftovers) == 0 {
+		return
+	}
+	log.Printf("The previous session was killed and left processes running:\n", color.FgYellow, color.Bold)
+	for _, g := range leftovers {
+		members := strings.Join(session.Members(g.PGID), ", ")
+		if members == "" {
+			members = "unknown processes"
+		}
+		log.Printf("  %v: process group %v: %v\n", g.Task, g.PGID, members)
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		log.Printf("Kill them with: kill -9 -- -<process group>\n")
+		_ = session.Forget(state)
+		return
+	}
+	log.Printf("Kill them? [y/N] ", color.Bold)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+		_ = session.Forget(state)
+		return
+	}
+	if err := session.Kill(state, leftovers); err != nil {
+		log.Errorf("%v\n", err, color.FgRed, color.Bold)
+	}
 }
 
 // Completer for autocomplete in interactive mode.
@@ -287,7 +348,7 @@ func startInteractive(ctx context.Context, log *output.Logger, c *configuration.
 	}
 
 	r := runner.New(log, c)
-	return r, &runner.Runtime{Executables: execs, Pods: pods}
+	return r, &runner.Runtime{Executables: execs, Pods: pods, Flows: flows, KubeConfigPath: kubeConfigPath, KubeContext: kubeContext}
 
 }
 
@@ -306,7 +367,7 @@ func startCommandLine(ctx context.Context, log *output.Logger, c *configuration.
 			}
 		}
 	}
-	return r, &runner.Runtime{Executables: execs, Pods: pods, KubeConfigPath: kubeConfigPath}
+	return r, &runner.Runtime{Executables: execs, Pods: pods, Flows: flows, KubeConfigPath: kubeConfigPath, KubeContext: kubeContext}
 }
 
 func init() {
@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/AntoineToussaint/kommence/pkg/control"
+	"github.com/AntoineToussaint/kommence/pkg/output"
+	"github.com/AntoineToussaint/kommence/pkg/runner"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the tasks of the running session and their resources",
+	Run: func(cmd *cobra.Command, args []string) {
+		log := output.NewLogger(debug)
+		socket, err := control.Socket(kommenceDir)
+		if err != nil {
+			log.Errorf(err.Error()+"\n", color.FgRed, color.Bold)
+			os.Exit(1)
+		}
+		status, err := control.NewClient(socket).Status(context.Background())
+		if err != nil {
+			log.Errorf(err.Error()+"\n", color.FgRed, color.Bold)
+			os.Exit(1)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "TASK\tSTATE\tMEMORY\tCPU\tTHREADS\tFDS\tPORTS")
+		for _, s := range status {
+			state := s.State
+			if s.State == runner.Failed && s.ExitCode != nil {
+				state = fmt.Sprintf("%v (exit code %v)", state, *s.ExitCode)
+			}
+			if s.State == runner.Blocked && s.BlockedBy != "" {
+				state = fmt.Sprintf("%v: %v failed", state, s.BlockedBy)
+			}
+			if s.Resources == nil {
+				fmt.Fprintf(w, "%v\t%v\t-\t-\t-\t-\t%v\n", s.ID, state, formatPorts(s.Ports))
+				continue
+			}
+			r := s.Resources
+			fmt.Fprintf(w, "%v\t%v\t%v\t%.1f%%\t%v\t%v\t%v\n", s.ID, state, output.FormatBytes(r.Memory), r.CPU, r.Threads, r.FDs, formatPorts(s.Ports))
+		}
+		_ = w.Flush()
+	},
+}
+
+// formatPorts as name=port, sorted by name.
+func formatPorts(ports map[string]int) string {
+	if len(ports) == 0 {
+		return "-"
+	}
+	var names []string
+	for name := range ports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var formatted []string
+	for _, name := range names {
+		formatted = append(formatted, fmt.Sprintf("%v=%v", name, ports[name]))
+	}
+	return strings.Join(formatted, ",")
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+}
@@ -7,9 +7,11 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/AntoineToussaint/kommence/pkg/output"
 	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 // Executable configuration.
@@ -18,11 +20,126 @@ type Executable struct {
 	Shortcut    string
 	Description string
 	Cmd         string
+	Build       string
 	Path        string
 	Env         map[string]string
 	Delay       string
 	Watch       []string
+	Ignore      []string
+	GitIgnore   *bool `yaml:"gitignore"`
+	Debounce    string
 	StdErr      string `yaml:"std_err"`
+	Color       string
+	Highlight   []Highlight
+	Monitor     Monitor
+	Limits      Limits
+	Hooks       `yaml:",inline"`
+
+	// Kind is service (default) or job
+	Kind          string
+	DependsOn     []string `yaml:"depends_on"`
+	StopOnFailure bool     `yaml:"stop_on_failure"`
+
+	// TTY runs the executable under a pseudo-terminal
+	TTY bool `yaml:"tty"`
+	// KeepColors prints the ANSI colors of the output instead of styling it
+	KeepColors bool `yaml:"keep_colors"`
+	// Stdin is a pipe that kommence attach writes to, executables with a TTY always have one
+	Stdin bool
+
+	// Ports used by the executable, checked before it starts
+	Ports Ports
+}
+
+// Limits on the resources of an executable and all its children.
+type Limits struct {
+	// Memory like 512Mi
+	Memory string
+	// CPU in cores, like 0.5
+	CPU       float64
+	OpenFiles uint64 `yaml:"open_files"`
+	Processes uint64
+}
+
+// IsSet returns true if any limit is configured.
+func (l Limits) IsSet() bool {
+	return l.Memory != "" || l.CPU > 0 || l.OpenFiles > 0 || l.Processes > 0
+}
+
+// GetMemory returns the memory limit in bytes, 0 if none.
+func (l Limits) GetMemory() uint64 {
+	q, err := resource.ParseQuantity(l.Memory)
+	if err != nil {
+		return 0
+	}
+	return uint64(q.Value())
+}
+
+func (l Limits) validate() error {
+	if l.Memory != "" {
+		if _, err := resource.ParseQuantity(l.Memory); err != nil {
+			return errors.Wrapf(err, "invalid memory limit")
+		}
+	}
+	if l.CPU < 0 {
+		return fmt.Errorf("invalid cpu limit: %v", l.CPU)
+	}
+	return nil
+}
+
+// UseGitIgnore returns true unless .gitignore files are explicitly not honoured by the watcher.
+func (e *Executable) UseGitIgnore() bool {
+	return e.GitIgnore == nil || *e.GitIgnore
+}
+
+// GetDebounce returns the debounce window of the watcher, 0 for the default.
+func (e *Executable) GetDebounce() time.Duration {
+	d, _ := time.ParseDuration(e.Debounce)
+	return d
+}
+
+// Monitor configures resource monitoring: warnings are sent when a threshold is exceeded.
+type Monitor struct {
+	Disabled bool
+	Interval string
+	// Memory threshold, like 512Mi
+	Memory string
+	// CPU threshold in percent of one core
+	CPU float64
+}
+
+const DefaultMonitorInterval = 2 * time.Second
+
+// GetInterval returns the sampling interval.
+func (m Monitor) GetInterval() time.Duration {
+	d, err := time.ParseDuration(m.Interval)
+	if err != nil || d <= 0 {
+		return DefaultMonitorInterval
+	}
+	return d
+}
+
+// GetMemory returns the memory threshold in bytes, 0 if none.
+func (m Monitor) GetMemory() uint64 {
+	q, err := resource.ParseQuantity(m.Memory)
+	if err != nil {
+		return 0
+	}
+	return uint64(q.Value())
+}
+
+func (m Monitor) validate() error {
+	if m.Interval != "" {
+		if _, err := time.ParseDuration(m.Interval); err != nil {
+			return errors.Wrapf(err, "invalid monitor interval")
+		}
+	}
+	if m.Memory != "" {
+		if _, err := resource.ParseQuantity(m.Memory); err != nil {
+			return errors.Wrapf(err, "invalid monitor memory")
+		}
+	}
+	return nil
 }
 
 const (
@@ -31,6 +148,19 @@ const (
 	AsLog   = "log"
 )
 
+// Kinds of executables.
+const (
+	// Service runs until stopped
+	Service = "service"
+	// Job runs to completion, like migrations or code generation
+	Job = "job"
+)
+
+// IsJob returns true for executables running to completion.
+func (e *Executable) IsJob() bool {
+	return e.Kind == Job
+}
+
 // NewExecutable attempts to load a configuration.
 func NewExecutable(f string) (*Executable, error) {
 	data, err := os.ReadFile(f)
@@ -45,6 +175,35 @@ func NewExecutable(f string) (*Executable, error) {
 	if cfg.Cmd == "" {
 		return nil, fmt.Errorf("command required")
 	}
+	if err := output.ValidColor(cfg.Color); err != nil {
+		return nil, err
+	}
+	if err := validateHighlights(cfg.Highlight); err != nil {
+		return nil, err
+	}
+	if cfg.Debounce != "" {
+		if _, err := time.ParseDuration(cfg.Debounce); err != nil {
+			return nil, errors.Wrapf(err, "invalid debounce")
+		}
+	}
+	if err := cfg.Monitor.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Limits.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Hooks.validate(); err != nil {
+		return nil, err
+	}
+	if cfg.Kind == "" {
+		cfg.Kind = Service
+	}
+	if cfg.Kind != Service && cfg.Kind != Job {
+		return nil, fmt.Errorf("invalid kind %v: expected %v or %v", cfg.Kind, Service, Job)
+	}
+	if cfg.StopOnFailure && !cfg.IsJob() {
+		return nil, fmt.Errorf("stop_on_failure is only supported by jobs")
+	}
 	if cfg.StdErr == "" {
 		cfg.StdErr = Ignore
 	}
@@ -101,9 +260,75 @@ func NewExecutableConfiguration(log *output.Logger, p string) (*Executables, err
 	if err != nil {
 		return nil, fmt.Errorf("error loading configurations %v: %v", p, err)
 	}
+	if err := config.validateDependencies(); err != nil {
+		return nil, err
+	}
 	return &config, nil
 }
 
+// validateDependencies checks that executables only depend on jobs, without cycles.
+func (c *Executables) validateDependencies() error {
+	visiting := make(map[string]bool)
+	visited := make(map[string]bool)
+	var visit func(e *Executable) error
+	visit = func(e *Executable) error {
+		if visited[e.ID] {
+			return nil
+		}
+		if visiting[e.ID] {
+			return fmt.Errorf("dependency cycle on %v", e.ID)
+		}
+		visiting[e.ID] = true
+		for _, d := range e.DependsOn {
+			dep, ok := c.Get(d)
+			if !ok {
+				return fmt.Errorf("%v depends on unknown executable %v", e.ID, d)
+			}
+			if !dep.IsJob() {
+				return fmt.Errorf("%v depends on %v which is not a job", e.ID, d)
+			}
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		visited[e.ID] = true
+		return nil
+	}
+	for _, e := range c.Commands {
+		if err := visit(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WithDependencies returns the executables preceded by the jobs they depend on, recursively.
+// Unknown executables are kept as is.
+func (c *Executables) WithDependencies(execs []string) []string {
+	var all []string
+	seen := make(map[string]bool)
+	var add func(x string)
+	add = func(x string) {
+		e, ok := c.Get(x)
+		if !ok {
+			all = append(all, x)
+			return
+		}
+		if seen[e.ID] {
+			return
+		}
+		seen[e.ID] = true
+		for _, d := range e.DependsOn {
+			add(d)
+		}
+		all = append(all, e.ID)
+	}
+	for _, x := range execs {
+		add(x)
+	}
+	return all
+}
+
 // Get an Executable by ID or shortcut.
 func (c *Executables) Get(x string) (*Executable, bool) {
 	exec, ok := c.Commands[x]
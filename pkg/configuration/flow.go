@@ -19,6 +19,7 @@ type Flow struct {
 	Description string
 	Executables []string
 	Pods        []string
+	Hooks       `yaml:",inline"`
 }
 
 // NewFlow attempts to load a configuration.
@@ -32,6 +33,9 @@ func NewFlow(f string) (*Flow, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "can't unmarshal flow configuration")
 	}
+	if err := cfg.Hooks.validate(); err != nil {
+		return nil, err
+	}
 
 	if cfg.Description == "" {
 		cfg.Description = "No description available"
@@ -0,0 +1,74 @@
+package configuration
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const DefaultHookTimeout = time.Minute
+
+// Hook is a command run around the lifecycle of a task.
+// It can be written as a plain command or with a timeout:
+//
+//	before_start:
+//	  - docker compose up -d db
+//	  - cmd: ./scripts/seed.sh
+//	    timeout: 2m
+type Hook struct {
+	Cmd     string
+	Timeout string
+}
+
+// UnmarshalYAML accepts a plain command.
+func (h *Hook) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var cmd string
+	if err := unmarshal(&cmd); err == nil {
+		h.Cmd = cmd
+		return nil
+	}
+	type plain Hook
+	return unmarshal((*plain)(h))
+}
+
+// GetTimeout returns how long the hook can run.
+func (h Hook) GetTimeout() time.Duration {
+	d, err := time.ParseDuration(h.Timeout)
+	if err != nil || d <= 0 {
+		return DefaultHookTimeout
+	}
+	return d
+}
+
+func (h Hook) validate() error {
+	if h.Cmd == "" {
+		return fmt.Errorf("hook command required")
+	}
+	if h.Timeout != "" {
+		if _, err := time.ParseDuration(h.Timeout); err != nil {
+			return errors.Wrapf(err, "invalid timeout for hook %v", h.Cmd)
+		}
+	}
+	return nil
+}
+
+// Hooks of an Executable or a Flow, run in order.
+// A failing before_start hook prevents the start, the other failures are only reported.
+type Hooks struct {
+	BeforeStart []Hook `yaml:"before_start"`
+	AfterStart  []Hook `yaml:"after_start"`
+	BeforeStop  []Hook `yaml:"before_stop"`
+	AfterStop   []Hook `yaml:"after_stop"`
+}
+
+func (h Hooks) validate() error {
+	for _, hooks := range [][]Hook{h.BeforeStart, h.AfterStart, h.BeforeStop, h.AfterStop} {
+		for _, hook := range hooks {
+			if err := hook.validate(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -10,14 +10,22 @@ import (
 )
 
 type Configuration struct {
-	Execs *Executables
-	Pods  *Pods
-	Flows *Flows
+	Settings *Settings
+	Execs    *Executables
+	Pods     *Pods
+	Flows    *Flows
 }
 
 func Load(logger *output.Logger, p string) (*Configuration, error) {
 	cfg := Configuration{}
 
+	// Global settings
+	settings, err := NewSettings(logger, path.Join(p, "/kommence.yml"))
+	if err != nil {
+		return nil, fmt.Errorf("can't load settings: %v", err)
+	}
+	cfg.Settings = settings
+
 	// Executable configurations
 	execs, err := NewExecutableConfiguration(logger, path.Join(p, "/executables"))
 	if err != nil {
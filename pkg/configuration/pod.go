@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/AntoineToussaint/kommence/pkg/output"
 	"github.com/pkg/errors"
@@ -19,8 +20,116 @@ type Pod struct {
 	Service     string
 	Namespace   string
 	Container   string
-	LocalPort   int `yaml:"localPort"`
-	PodPort     int `yaml:"podPort"`
+	LocalPort   Port   `yaml:"localPort"`
+	PodPort     string `yaml:"podPort"`
+	Color       string
+
+	// Pod selection: one of Pod, Selector, Deployment, StatefulSet or Service, otherwise Name is a prefix
+	Selector    string
+	Pod         string
+	Deployment  string
+	StatefulSet string `yaml:"statefulset"`
+
+	// Ports forwarded over one connection, instead of LocalPort and PodPort
+	Ports []PortForward
+
+	Logs PodLogs
+
+	// Kube context of the cluster, the one of --context or the current context by default
+	Context string
+
+	// Events of the pod and of its owners are shown with its logs
+	Events bool
+}
+
+// PodLogs selects the logs followed: all the containers by default, or Container.
+type PodLogs struct {
+	Containers     []string
+	InitContainers bool `yaml:"initContainers"`
+	// Since is how far back logs are shown, all by default
+	Since string
+	// Tail is how many lines are shown, all by default
+	Tail       *int64
+	Timestamps bool
+}
+
+// GetSince returns how far back logs are shown, 0 for all.
+func (l PodLogs) GetSince() time.Duration {
+	d, _ := time.ParseDuration(l.Since)
+	return d
+}
+
+// Target describes how the pod is selected.
+func (p Pod) Target() string {
+	switch {
+	case p.Pod != "":
+		return fmt.Sprintf("pod %v", p.Pod)
+	case p.Selector != "":
+		return fmt.Sprintf("selector %v", p.Selector)
+	case p.Deployment != "":
+		return fmt.Sprintf("deployment %v", p.Deployment)
+	case p.StatefulSet != "":
+		return fmt.Sprintf("statefulset %v", p.StatefulSet)
+	case p.Service != "":
+		return fmt.Sprintf("service %v", p.Service)
+	}
+	return fmt.Sprintf("name %v", p.Name)
+}
+
+func (p Pod) validate() error {
+	set := 0
+	for _, s := range []string{p.Pod, p.Selector, p.Deployment, p.StatefulSet, p.Service} {
+		if s != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return fmt.Errorf("pod %v: only one of pod, selector, deployment, statefulset or service can be set", p.ID)
+	}
+	if set == 0 && p.Name == "" {
+		return fmt.Errorf("pod %v: one of name, pod, selector, deployment, statefulset or service required", p.ID)
+	}
+	if len(p.Ports) > 0 && (p.PodPort != "" || p.LocalPort != Port{}) {
+		return fmt.Errorf("pod %v: ports replaces localPort and podPort", p.ID)
+	}
+	if p.Logs.Since != "" {
+		if _, err := time.ParseDuration(p.Logs.Since); err != nil {
+			return errors.Wrapf(err, "pod %v: invalid logs since", p.ID)
+		}
+	}
+	names := make(map[string]bool)
+	for _, f := range p.Ports {
+		if names[f.Name] {
+			return fmt.Errorf("pod %v: pod port %v forwarded twice", p.ID, f.Name)
+		}
+		names[f.Name] = true
+	}
+	return nil
+}
+
+// LocalPortName is the name of the local port of a pod: ${ports.<pod>.local}
+const LocalPortName = "local"
+
+// Forwards returns the forwarded ports, localPort and podPort being one named local.
+func (p Pod) Forwards() []PortForward {
+	if len(p.Ports) > 0 {
+		return p.Ports
+	}
+	if p.PodPort == "" {
+		return nil
+	}
+	return []PortForward{{Local: p.LocalPort, Remote: p.PodPort, Name: LocalPortName}}
+}
+
+// GetPorts returns the local ports of the pod, by name.
+func (p Pod) GetPorts() Ports {
+	ports := make(Ports)
+	for _, f := range p.Forwards() {
+		if f.Local.Number != 0 || f.Local.Auto {
+			ports[f.Name] = f.Local
+		}
+	}
+	return ports
 }
 
 func NewPod(f string) (*Pod, error) {
@@ -38,6 +147,12 @@ func NewPod(f string) (*Pod, error) {
 	if cfg.Namespace == "" {
 		return nil, nil
 	}
+	if err := output.ValidColor(cfg.Color); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
 	if cfg.Description == "" {
 		cfg.Description = "No description available"
 	}
@@ -46,10 +161,11 @@ func NewPod(f string) (*Pod, error) {
 
 func (p Pod) ToString(log *output.Logger) string {
 	return output.FromTemplate(log, `- {{.ID}}
-  name: {{.Name}}
+  target: {{.Target}}
   namespace: {{.Namespace}}
+  {{if .Context}}context: {{.Context}}{{end}}
   {{if .Container}}container: {{.Container}}{{end}}
-  port: {{.LocalPort}} -> {{.PodPort}}
+  ports:{{range .Forwards}} {{.}}{{end}}
   Description: {{.Description}}
 `, p)
 }
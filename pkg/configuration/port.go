@@ -0,0 +1,111 @@
+package configuration
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// AutoPort allocates a free port when the task starts.
+const AutoPort = "auto"
+
+// Port is a local port number or auto:
+//
+//	ports:
+//	  http: 8080
+//	  debug: auto
+//
+// Other tasks use it as ${ports.<task>.<name>}.
+type Port struct {
+	Number int
+	Auto   bool
+}
+
+// ParsePort parses a number or auto.
+func ParsePort(s string) (Port, error) {
+	if s == AutoPort {
+		return Port{Auto: true}, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 || n > 65535 {
+		return Port{}, fmt.Errorf("invalid port %q: a number or %v required", s, AutoPort)
+	}
+	return Port{Number: n}, nil
+}
+
+// UnmarshalYAML accepts a number or auto.
+func (p *Port) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	port, err := ParsePort(s)
+	if err != nil {
+		return err
+	}
+	*p = port
+	return nil
+}
+
+func (p Port) String() string {
+	if p.Auto {
+		return AutoPort
+	}
+	return strconv.Itoa(p.Number)
+}
+
+// Ports used by a task, by name.
+type Ports map[string]Port
+
+// PortForward forwards a local port to a port of a pod, or of a service with service:
+//
+//	ports:
+//	  - 8080:80
+//	  - 9090:grpc
+//	  - 0:metrics
+//
+// The pod port is a number or a name. A local port 0 or auto is allocated.
+// Other tasks use the local port as ${ports.<pod>.<pod port>}.
+type PortForward struct {
+	Local  Port
+	Remote string
+	// Name of the local port
+	Name string
+}
+
+// ParsePortForward parses local:remote, or a port forwarded to the same port.
+func ParsePortForward(s string) (PortForward, error) {
+	local, remote, found := strings.Cut(s, ":")
+	if !found {
+		remote = local
+	}
+	if local == "0" {
+		local = AutoPort
+	}
+	port, err := ParsePort(local)
+	if err != nil {
+		return PortForward{}, fmt.Errorf("invalid port forward %q: %v", s, err)
+	}
+	if remote == "" {
+		return PortForward{}, fmt.Errorf("invalid port forward %q: pod port required", s)
+	}
+	return PortForward{Local: port, Remote: remote, Name: remote}, nil
+}
+
+// UnmarshalYAML accepts local:remote.
+func (f *PortForward) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	forward, err := ParsePortForward(s)
+	if err != nil {
+		return err
+	}
+	*f = forward
+	return nil
+}
+
+func (f PortForward) String() string {
+	return fmt.Sprintf("%v:%v", f.Local, f.Remote)
+}
@@ -0,0 +1,30 @@
+package configuration_test
+
+import (
+	"testing"
+
+	"github.com/AntoineToussaint/kommence/pkg/configuration"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestPortForwards(t *testing.T) {
+	var pod configuration.Pod
+	err := yaml.Unmarshal([]byte("ports: [\"8080:80\", \"9090:grpc\", \"0:metrics\", \"5432\"]"), &pod)
+	assert.NoError(t, err)
+	assert.Equal(t, []configuration.PortForward{
+		{Local: configuration.Port{Number: 8080}, Remote: "80", Name: "80"},
+		{Local: configuration.Port{Number: 9090}, Remote: "grpc", Name: "grpc"},
+		{Local: configuration.Port{Auto: true}, Remote: "metrics", Name: "metrics"},
+		{Local: configuration.Port{Number: 5432}, Remote: "5432", Name: "5432"},
+	}, pod.Forwards())
+
+	for _, invalid := range []string{"http:80", "8080:", "70000:80"} {
+		_, err := configuration.ParsePortForward(invalid)
+		assert.Error(t, err, invalid)
+	}
+
+	// The single port is named local
+	legacy := configuration.Pod{LocalPort: configuration.Port{Number: 8080}, PodPort: "80"}
+	assert.Equal(t, configuration.Ports{configuration.LocalPortName: {Number: 8080}}, legacy.GetPorts())
+}
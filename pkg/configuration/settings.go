@@ -0,0 +1,56 @@
+package configuration
+
+import (
+	"os"
+
+	"github.com/AntoineToussaint/kommence/pkg/output"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// Highlight styles the parts of log lines matching a pattern.
+type Highlight struct {
+	Pattern string
+	Style   string
+}
+
+// Validate the pattern and the style.
+func (h Highlight) Validate() error {
+	_, err := output.NewHighlightRule(&output.Styler{}, h.Pattern, h.Style)
+	return err
+}
+
+func validateHighlights(highlights []Highlight) error {
+	for _, h := range highlights {
+		if err := h.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Settings apply to every task.
+type Settings struct {
+	Highlight []Highlight
+}
+
+// NewSettings loads the global settings, if any.
+func NewSettings(log *output.Logger, f string) (*Settings, error) {
+	var cfg Settings
+	data, err := os.ReadFile(f)
+	if os.IsNotExist(err) {
+		log.Debugf("No settings found in kommence config\n")
+		return &cfg, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't load file: %v", f)
+	}
+	err = yaml.Unmarshal(data, &cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't unmarshal settings")
+	}
+	if err := validateHighlights(cfg.Highlight); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
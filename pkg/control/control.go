@@ -0,0 +1,233 @@
+package control
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/AntoineToussaint/kommence/pkg/output"
+	"github.com/AntoineToussaint/kommence/pkg/runner"
+)
+
+// Socket returns the path of the control socket for the session running the configuration in dir.
+func Socket(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(abs))
+	return filepath.Join(os.TempDir(), fmt.Sprintf("kommence-%d-%x.sock", os.Getuid(), h.Sum64())), nil
+}
+
+// Server exposes a running session over a unix socket.
+type Server struct {
+	logger *output.Logger
+	runner *runner.Runner
+	socket string
+	server *http.Server
+	// done closes attached connections, which the http server doesn't track
+	done chan struct{}
+}
+
+func NewServer(logger *output.Logger, socket string, r *runner.Runner) *Server {
+	s := &Server{logger: logger, runner: r, socket: socket, done: make(chan struct{})}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.status)
+	mux.HandleFunc("/attach", s.attach)
+	s.server = &http.Server{Handler: mux}
+	return s
+}
+
+// Start listening on the socket.
+func (s *Server) Start() error {
+	if conn, err := net.Dial("unix", s.socket); err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("another kommence session is running with this configuration")
+	}
+	// A previous session may have left its socket behind
+	_ = os.Remove(s.socket)
+	l, err := net.Listen("unix", s.socket)
+	if err != nil {
+		return fmt.Errorf("can't listen on %v: %v", s.socket, err)
+	}
+	go func() {
+		if err := s.server.Serve(l); err != nil && err != http.ErrServerClosed {
+			s.logger.Errorf("control server stopped: %v\n", err)
+		}
+	}()
+	return nil
+}
+
+// Stop the server and remove the socket.
+func (s *Server) Stop() error {
+	close(s.done)
+	err := s.server.Close()
+	_ = os.Remove(s.socket)
+	return err
+}
+
+func (s *Server) status(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s.runner.Status())
+}
+
+// attach a client to a task: after the response headers, the connection carries the input of the task
+// one way and its output lines the other way, until the client closes its side.
+func (s *Server) attach(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "attach requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+	out := make(chan string, 1024)
+	in, tty, detach, err := s.runner.Attach(r.URL.Query().Get("task"), out)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer detach()
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "can't attach", http.StatusInternalServerError)
+		return
+	}
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		s.logger.Errorf("can't attach: %v\n", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\n%v: %v\r\n\r\n", ttyHeader, tty); err != nil {
+		return
+	}
+
+	detached := make(chan struct{})
+	go func() {
+		defer close(detached)
+		data := make([]byte, 4096)
+		for {
+			n, err := buf.Read(data)
+			if n > 0 {
+				// Input is lost while the task restarts
+				_, _ = in.Write(data[:n])
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case line := <-out:
+			if _, err := io.WriteString(conn, line+"\n"); err != nil {
+				return
+			}
+		case <-detached:
+			return
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// ttyHeader tells an attached client that the task runs in a terminal.
+const ttyHeader = "X-Kommence-Tty"
+
+// Client talks to a running session.
+type Client struct {
+	socket string
+	http   *http.Client
+}
+
+func NewClient(socket string) *Client {
+	return &Client{socket: socket, http: &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}}}
+}
+
+// Status of the tasks of the session.
+func (c *Client) Status(ctx context.Context) ([]runner.TaskStatus, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://kommence/status", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("no kommence session running: %v", err)
+	}
+	defer resp.Body.Close()
+	var status []runner.TaskStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("invalid status: %v", err)
+	}
+	return status, nil
+}
+
+// Attachment is a connection to a task: writes go to its input and reads return its output lines.
+type Attachment struct {
+	// TTY is true when the task runs in a terminal
+	TTY    bool
+	conn   *net.UnixConn
+	reader *bufio.Reader
+}
+
+// Attach to a task of the session.
+func (c *Client) Attach(task string) (*Attachment, error) {
+	conn, err := net.Dial("unix", c.socket)
+	if err != nil {
+		return nil, fmt.Errorf("no kommence session running: %v", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, "http://kommence/attach?task="+url.QueryEscape(task), nil)
+	if err == nil {
+		err = req.Write(conn)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		_ = conn.Close()
+		return nil, fmt.Errorf("can't attach: %v", strings.TrimSpace(string(body)))
+	}
+	return &Attachment{TTY: resp.Header.Get(ttyHeader) == "true", conn: conn.(*net.UnixConn), reader: reader}, nil
+}
+
+// ReadLine returns the next output line of the task.
+func (a *Attachment) ReadLine() (string, error) {
+	line, err := a.reader.ReadString('\n')
+	return strings.TrimSuffix(line, "\n"), err
+}
+
+// Write to the input of the task.
+func (a *Attachment) Write(p []byte) (int, error) {
+	return a.conn.Write(p)
+}
+
+// Detach from the task: the output is read until the session closes the connection.
+func (a *Attachment) Detach() error {
+	return a.conn.CloseWrite()
+}
+
+// Close the connection.
+func (a *Attachment) Close() error {
+	return a.conn.Close()
+}
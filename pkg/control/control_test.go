@@ -0,0 +1,87 @@
+package control_test
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AntoineToussaint/kommence/pkg/configuration"
+	"github.com/AntoineToussaint/kommence/pkg/control"
+	"github.com/AntoineToussaint/kommence/pkg/output"
+	"github.com/AntoineToussaint/kommence/pkg/runner"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatus(t *testing.T) {
+	log := output.NewLogger(true)
+	r := runner.New(log, &configuration.Configuration{})
+	socket := filepath.Join(t.TempDir(), "kommence.sock")
+
+	server := control.NewServer(log, socket, r)
+	assert.NoError(t, server.Start())
+	defer server.Stop()
+
+	// Only one session at a time
+	assert.Error(t, control.NewServer(log, socket, r).Start())
+
+	status, err := control.NewClient(socket).Status(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, status)
+}
+
+func TestAttach(t *testing.T) {
+	log := output.NewLogger(true)
+	repl := &configuration.Executable{ID: "repl", Cmd: "cat", Stdin: true, Monitor: configuration.Monitor{Disabled: true}}
+	service := &configuration.Executable{ID: "service", Cmd: "sleep 5", Monitor: configuration.Monitor{Disabled: true}}
+	r := runner.New(log, &configuration.Configuration{Execs: &configuration.Executables{
+		Commands:  map[string]*configuration.Executable{repl.ID: repl, service.ID: service},
+		Shortcuts: map[string]*configuration.Executable{},
+	}})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx, &runner.Runtime{Executables: []string{"repl", "service"}})
+	defer r.Stop(ctx)
+
+	socket := filepath.Join(t.TempDir(), "kommence.sock")
+	server := control.NewServer(log, socket, r)
+	assert.NoError(t, server.Start())
+	defer server.Stop()
+	client := control.NewClient(socket)
+	assert.Eventually(t, func() bool {
+		return len(r.Status()) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	// Only executables with a stdin
+	_, err := client.Attach("service")
+	assert.EqualError(t, err, "can't attach: service doesn't accept input: set stdin: true")
+	_, err = client.Attach("unknown")
+	assert.EqualError(t, err, "can't attach: unknown task unknown")
+
+	a, err := client.Attach("repl")
+	assert.NoError(t, err)
+	defer a.Close()
+	assert.False(t, a.TTY)
+	// One client at a time
+	_, err = client.Attach("repl")
+	assert.Error(t, err)
+
+	_, err = a.Write([]byte("hello\n"))
+	assert.NoError(t, err)
+	line, err := a.ReadLine()
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", line)
+
+	// The session closes the connection once detached
+	assert.NoError(t, a.Detach())
+	_, err = a.ReadLine()
+	assert.Equal(t, io.EOF, err)
+	assert.Eventually(t, func() bool {
+		a, err := client.Attach("repl")
+		if err == nil {
+			_ = a.Close()
+		}
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+}
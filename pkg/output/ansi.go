@@ -0,0 +1,14 @@
+package output
+
+import "regexp"
+
+// ANSIReset ends the styles left open by a line with ANSI sequences.
+const ANSIReset = "\x1b[0m"
+
+// ansi matches CSI sequences (colors, cursor moves), OSC sequences (titles, links) and other escapes.
+var ansi = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+// StripANSI removes ANSI escape sequences, like the colors of a program running in a terminal.
+func StripANSI(s string) string {
+	return ansi.ReplaceAllString(s, "")
+}
@@ -0,0 +1,16 @@
+package output_test
+
+import (
+	"testing"
+
+	"github.com/AntoineToussaint/kommence/pkg/output"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStripANSI(t *testing.T) {
+	assert.Equal(t, "plain", output.StripANSI("plain"))
+	assert.Equal(t, "  VITE ready in 120 ms", output.StripANSI("\x1b[32m\x1b[1m  VITE\x1b[22m ready in \x1b[38;5;208m120\x1b[0m ms"))
+	assert.Equal(t, "building", output.StripANSI("\x1b[2K\x1b[1Gbuilding"))
+	assert.Equal(t, "title", output.StripANSI("\x1b]0;vite\x07title"))
+	assert.Equal(t, "link", output.StripANSI("\x1b]8;;http://localhost\x1b\\link\x1b]8;;\x1b\\"))
+}
@@ -0,0 +1,82 @@
+package output
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/fatih/color"
+)
+
+// HighlightRule styles every match of a pattern.
+type HighlightRule struct {
+	Pattern *regexp.Regexp
+	Style   Style
+}
+
+// NewHighlightRule compiles a pattern and parses its style.
+func NewHighlightRule(styler *Styler, pattern string, style string) (HighlightRule, error) {
+	r, err := regexp.Compile(pattern)
+	if err != nil {
+		return HighlightRule{}, fmt.Errorf("invalid highlight pattern %v: %v", pattern, err)
+	}
+	s, err := styler.ParseStyle(style)
+	if err != nil {
+		return HighlightRule{}, fmt.Errorf("invalid highlight style %v: %v", style, err)
+	}
+	return HighlightRule{Pattern: r, Style: s}, nil
+}
+
+// Highlighter applies highlight rules to a log line. The first matching rule wins.
+type Highlighter struct {
+	Rules []HighlightRule
+}
+
+// Apply styles the matches in s and uses base for everything else.
+func (h *Highlighter) Apply(s string, base Style) string {
+	if h == nil || len(h.Rules) == 0 || s == "" {
+		return Colorize(s, base)
+	}
+	// Which rule styles each byte, -1 for none
+	owners := make([]int, len(s))
+	for i := range owners {
+		owners[i] = -1
+	}
+	for i, rule := range h.Rules {
+		for _, m := range rule.Pattern.FindAllStringIndex(s, -1) {
+			for j := m[0]; j < m[1]; j++ {
+				if owners[j] == -1 {
+					owners[j] = i
+				}
+			}
+		}
+	}
+	var out string
+	start := 0
+	for i := 1; i <= len(s); i++ {
+		if i < len(s) && owners[i] == owners[start] {
+			continue
+		}
+		style := base
+		if owner := owners[start]; owner != -1 {
+			style = h.Rules[owner].Style
+		}
+		out += Colorize(s[start:i], style)
+		start = i
+	}
+	return out
+}
+
+var levelStyles = map[string]Style{
+	"ERROR": {color.FgRed, color.Bold},
+	"WARN":  {color.FgYellow, color.Bold},
+	"INFO":  {color.FgGreen},
+	"DEBUG": {color.FgBlue},
+}
+
+// LevelStyle returns the style of a parsed log level, or base if unknown.
+func LevelStyle(level string, base Style) Style {
+	if style, ok := levelStyles[level]; ok {
+		return style
+	}
+	return base
+}
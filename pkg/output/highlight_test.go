@@ -0,0 +1,35 @@
+package output_test
+
+import (
+	"testing"
+
+	"github.com/AntoineToussaint/kommence/pkg/output"
+	"github.com/fatih/color"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHighlighter(t *testing.T) {
+	noColor := color.NoColor
+	color.NoColor = false
+	defer func() { color.NoColor = noColor }()
+
+	styler := &output.Styler{}
+	errors, err := output.NewHighlightRule(styler, "ERROR|panic", "red bold")
+	assert.NoError(t, err)
+	ids, err := output.NewHighlightRule(styler, `req-\d+`, "underline")
+	assert.NoError(t, err)
+	h := output.Highlighter{Rules: []output.HighlightRule{errors, ids}}
+
+	base := output.Style{color.FgCyan}
+	assert.Equal(t,
+		"\x1b[36mgot \x1b[0m\x1b[31;1mERROR\x1b[0m\x1b[36m for \x1b[0m\x1b[4mreq-42\x1b[0m",
+		h.Apply("got ERROR for req-42", base))
+
+	// No match
+	assert.Equal(t, "\x1b[36mfine\x1b[0m", h.Apply("fine", base))
+
+	_, err = output.NewHighlightRule(styler, "(", "red")
+	assert.Error(t, err)
+	_, err = output.NewHighlightRule(styler, "x", "sparkly")
+	assert.Error(t, err)
+}
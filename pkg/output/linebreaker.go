@@ -1,29 +1,189 @@
 package output
 
+import (
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultMaxLineLength is the size after which a line is split into several messages.
+	DefaultMaxLineLength = 64 * 1024
+	// DefaultIdleTimeout is how long a partial line (a prompt for instance) waits before being flushed.
+	DefaultIdleTimeout = 250 * time.Millisecond
+)
+
+// LineBreaker is an io.Writer that splits a stream into one Message per line.
+//
+// Lines are terminated by "\n" or "\r\n". A lone "\r" (progress bars) rewinds the
+// current line, so only its latest state gets emitted. Lines longer than MaxLength
+// are split on a UTF-8 boundary, and a partial line is flushed once no data has been
+// received for IdleTimeout.
 type LineBreaker struct {
 	Output      chan Message
 	ID          string
+	MaxLength   int
+	IdleTimeout time.Duration
+	// Prefix of every line
+	Prefix string
+
 	messageType MessageType
+
+	mu          sync.Mutex
 	currentLine []byte
+	pendingCR   bool
+	flushed     bool
+	closed      bool
+	timer       *time.Timer
 }
 
-func NewLineBreaker(out chan Message, ID string, t MessageType) *LineBreaker {
-	return &LineBreaker{Output: out, ID: ID, messageType: t}
+type LineBreakerOption = func(*LineBreaker)
+
+// WithMaxLineLength sets the maximum length in bytes of a single message.
+func WithMaxLineLength(n int) LineBreakerOption {
+	return func(w *LineBreaker) {
+		w.MaxLength = n
+	}
+}
+
+// WithIdleTimeout sets how long to wait before flushing a partial line. Zero disables it.
+func WithIdleTimeout(d time.Duration) LineBreakerOption {
+	return func(w *LineBreaker) {
+		w.IdleTimeout = d
+	}
+}
+
+// WithPrefix prefixes every line, to tell several streams of a task apart.
+func WithPrefix(prefix string) LineBreakerOption {
+	return func(w *LineBreaker) {
+		w.Prefix = prefix
+	}
+}
+
+func NewLineBreaker(out chan Message, ID string, t MessageType, opts ...LineBreakerOption) *LineBreaker {
+	w := &LineBreaker{
+		Output:      out,
+		ID:          ID,
+		messageType: t,
+		MaxLength:   DefaultMaxLineLength,
+		IdleTimeout: DefaultIdleTimeout,
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	// We need room for at least one rune
+	if w.MaxLength < utf8.UTFMax {
+		w.MaxLength = utf8.UTFMax
+	}
+	return w
 }
 
 func (w *LineBreaker) Write(p []byte) (int, error) {
-	total := 0
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	for _, c := range p {
-		total++
-		if c == '\n' {
-			w.Output <- Message{ID: w.ID, Type: w.messageType, Content: string(w.currentLine)}
-			w.currentLine = nil
-			continue
+		if w.pendingCR {
+			w.pendingCR = false
+			if c == '\n' {
+				w.endLine()
+				continue
+			}
+			// Carriage return: the line is overwritten
+			w.currentLine = w.currentLine[:0]
+		}
+		switch c {
+		case '\n':
+			w.endLine()
+		case '\r':
+			w.pendingCR = true
+		default:
+			w.flushed = false
+			if len(w.currentLine) >= w.MaxLength {
+				w.split()
+			}
+			w.currentLine = append(w.currentLine, c)
 		}
-		w.currentLine = append(w.currentLine, c)
 	}
-	if w.currentLine != nil {
-		w.Output <- Message{ID: w.ID, Type: w.messageType, Content: string(w.currentLine)}
+	w.schedule()
+	return len(p), nil
+}
+
+// Close flushes whatever is left in the buffer.
+func (w *LineBreaker) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	if len(w.currentLine) > 0 {
+		w.emit(w.currentLine)
+		w.currentLine = nil
+	}
+	return nil
+}
+
+// endLine emits the current line unless it was already flushed as a partial line.
+func (w *LineBreaker) endLine() {
+	if len(w.currentLine) > 0 || !w.flushed {
+		w.emit(w.currentLine)
+	}
+	w.currentLine = w.currentLine[:0]
+	w.flushed = false
+}
+
+// split emits the longest prefix of the current line that doesn't cut a rune in half.
+func (w *LineBreaker) split() {
+	cut := runeBoundary(w.currentLine)
+	if cut == 0 {
+		cut = len(w.currentLine)
+	}
+	w.emit(w.currentLine[:cut])
+	w.currentLine = append(w.currentLine[:0], w.currentLine[cut:]...)
+}
+
+func (w *LineBreaker) schedule() {
+	if w.IdleTimeout <= 0 || len(w.currentLine) == 0 || w.closed {
+		return
+	}
+	if w.timer == nil {
+		w.timer = time.AfterFunc(w.IdleTimeout, w.idle)
+		return
+	}
+	w.timer.Reset(w.IdleTimeout)
+}
+
+func (w *LineBreaker) idle() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	cut := runeBoundary(w.currentLine)
+	if cut == 0 {
+		return
+	}
+	w.emit(w.currentLine[:cut])
+	w.currentLine = append(w.currentLine[:0], w.currentLine[cut:]...)
+	w.flushed = true
+}
+
+func (w *LineBreaker) emit(line []byte) {
+	w.Output <- Message{ID: w.ID, Type: w.messageType, Content: w.Prefix + string(line)}
+}
+
+// runeBoundary returns the length of the longest prefix of b not ending with an incomplete rune.
+func runeBoundary(b []byte) int {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if utf8.FullRune(b[i:]) {
+				return len(b)
+			}
+			return i
+		}
 	}
-	return total, nil
+	return len(b)
 }
@@ -0,0 +1,105 @@
+package output_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/AntoineToussaint/kommence/pkg/output"
+	"github.com/stretchr/testify/assert"
+)
+
+// breakLines writes all chunks to a LineBreaker and returns the emitted contents.
+func breakLines(chunks [][]byte, opts ...output.LineBreakerOption) []string {
+	rec := make(chan output.Message)
+	done := make(chan []string)
+	go func() {
+		var lines []string
+		for msg := range rec {
+			lines = append(lines, msg.Content)
+		}
+		done <- lines
+	}()
+	opts = append([]output.LineBreakerOption{output.WithIdleTimeout(0)}, opts...)
+	w := output.NewLineBreaker(rec, "test", output.Log, opts...)
+	for _, chunk := range chunks {
+		_, _ = w.Write(chunk)
+	}
+	_ = w.Close()
+	close(rec)
+	return <-done
+}
+
+func TestLineBreaker(t *testing.T) {
+	chunks := [][]byte{[]byte("hel"), []byte("lo\nwor"), []byte("ld\n"), []byte("partial")}
+	assert.Equal(t, []string{"hello", "world", "partial"}, breakLines(chunks))
+
+	// CRLF and progress bars
+	chunks = [][]byte{[]byte("a\r"), []byte("\nb\r\n10%\r50%\r"), []byte("100%\n")}
+	assert.Equal(t, []string{"a", "b", "100%"}, breakLines(chunks))
+
+	// Long lines are split
+	chunks = [][]byte{[]byte("abcdefghij\n")}
+	assert.Equal(t, []string{"abcd", "efgh", "ij"}, breakLines(chunks, output.WithMaxLineLength(4)))
+
+	// Never in the middle of a rune
+	chunks = [][]byte{[]byte("ab€d\n")}
+	assert.Equal(t, []string{"ab", "€d"}, breakLines(chunks, output.WithMaxLineLength(4)))
+}
+
+func TestLineBreakerIdleFlush(t *testing.T) {
+	rec := make(chan output.Message, 8)
+	w := output.NewLineBreaker(rec, "test", output.Log, output.WithIdleTimeout(10*time.Millisecond))
+	_, _ = w.Write([]byte("password: "))
+	msg := <-rec
+	assert.Equal(t, "password: ", msg.Content)
+
+	// The rest of the line comes in later: no duplicate
+	_, _ = w.Write([]byte("\nnext\n"))
+	assert.Equal(t, "next", (<-rec).Content)
+	_ = w.Close()
+	assert.Len(t, rec, 0)
+}
+
+func TestLineBreakerPrefix(t *testing.T) {
+	chunks := [][]byte{[]byte("started\nlisten"), []byte("ing\n")}
+	assert.Equal(t, []string{"[api] started", "[api] listening"}, breakLines(chunks, output.WithPrefix("[api] ")))
+}
+
+func FuzzLineBreaker(f *testing.F) {
+	f.Add([]byte("hello\nworld\n"), uint8(3), uint8(8))
+	f.Add([]byte("a\r\nb\rc\n\r"), uint8(1), uint8(4))
+	f.Add([]byte("日本語のテキスト\n"), uint8(2), uint8(5))
+	f.Add([]byte{0xe2, 0x82, 0xff, '\n', 0x80, 0x80}, uint8(1), uint8(4))
+	f.Fuzz(func(t *testing.T, data []byte, chunk uint8, max uint8) {
+		size := int(chunk)%16 + 1
+		maxLength := int(max)%32 + utf8.UTFMax
+		var chunks [][]byte
+		for i := 0; i < len(data); i += size {
+			end := i + size
+			if end > len(data) {
+				end = len(data)
+			}
+			chunks = append(chunks, data[i:end])
+		}
+		lines := breakLines(chunks, output.WithMaxLineLength(maxLength))
+
+		// Chunking doesn't matter
+		assert.Equal(t, breakLines([][]byte{data}, output.WithMaxLineLength(maxLength)), lines)
+
+		for _, line := range lines {
+			assert.LessOrEqual(t, len(line), maxLength)
+			assert.NotContains(t, line, "\n")
+			assert.NotContains(t, line, "\r")
+			if utf8.Valid(data) {
+				assert.True(t, utf8.ValidString(line))
+			}
+		}
+		// Without carriage returns, nothing is lost
+		if !bytes.ContainsRune(data, '\r') {
+			assert.Equal(t, strings.ReplaceAll(string(data), "\n", ""), strings.Join(lines, ""))
+		}
+	})
+}
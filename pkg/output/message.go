@@ -10,6 +10,24 @@ const (
 	PodConnection
 	Memory
 	CPU
+	// Build output
+	Build
+	// Built reports a successful build and its duration
+	Built
+	// BuildFailed reports a failed build: the previous process keeps running
+	BuildFailed
+	// Completed reports the success of a job
+	Completed
+	// Failed reports a job exiting with an error
+	Failed
+	// PodEvent is a Kubernetes event of a pod or of its owners
+	PodEvent
+	// PodWarning is a warning event, or a container of the pod killed or crashing
+	PodWarning
+	// BuildError is the error output of a build
+	BuildError
+	// Blocked reports a task that doesn't start, like after a failing before_start hook
+	Blocked
 )
 
 // Message are how processes communicate
@@ -20,4 +38,8 @@ type Message struct {
 	Type MessageType
 	// Content of the message
 	Content string
+	// Resources sample for Memory and CPU messages
+	Resources *Resources
+	// ExitCode of a job for Completed and Failed messages
+	ExitCode int
 }
@@ -0,0 +1,27 @@
+package output
+
+import "fmt"
+
+// Resources used by a task, summed over its process group.
+type Resources struct {
+	// Memory is the proportional set size in bytes
+	Memory uint64
+	// CPU is the percentage of one core
+	CPU     float64
+	Threads int
+	FDs     int
+}
+
+// FormatBytes in a human-readable way.
+func FormatBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
@@ -1,21 +1,227 @@
 package output
 
-import "github.com/fatih/color"
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"os"
+	"strconv"
+	"strings"
 
-const BackgroundOffset = 31
+	"github.com/fatih/color"
+)
 
 type Style = []interface{}
 
+// Palette is the set of colors a terminal supports.
+type Palette int
+
+const (
+	Basic Palette = iota
+	Extended
+	TrueColor
+)
+
+// SGR parameters for extended foreground colors
+const (
+	foreground = 38
+	extended   = 5
+	trueColor  = 2
+)
+
+// basicColors are the readable colors of the 16 colors palette: no black, white or grey.
+var basicColors = []color.Attribute{
+	color.FgRed, color.FgGreen, color.FgYellow, color.FgBlue, color.FgMagenta, color.FgCyan,
+	color.FgHiRed, color.FgHiGreen, color.FgHiYellow, color.FgHiBlue, color.FgHiMagenta, color.FgHiCyan,
+}
+
+var namedColors = map[string]color.Attribute{
+	"black":   color.FgBlack,
+	"red":     color.FgRed,
+	"green":   color.FgGreen,
+	"yellow":  color.FgYellow,
+	"blue":    color.FgBlue,
+	"magenta": color.FgMagenta,
+	"cyan":    color.FgCyan,
+	"white":   color.FgWhite,
+}
+
+// extendedColors are the colors of the 256 colors cube that are neither too dark nor too grey.
+var extendedColors = func() []int {
+	var colors []int
+	for i := 16; i < 232; i++ {
+		r, g, b := (i-16)/36, (i-16)/6%6, (i-16)%6
+		bright := r >= 3 || g >= 3 || b >= 3
+		grey := r >= 3 && g >= 3 && b >= 3
+		if bright && !grey {
+			colors = append(colors, i)
+		}
+	}
+	return colors
+}()
+
+// DetectPalette guesses the palette of the terminal from the environment.
+func DetectPalette() Palette {
+	switch strings.ToLower(os.Getenv("COLORTERM")) {
+	case "truecolor", "24bit":
+		return TrueColor
+	}
+	if strings.Contains(os.Getenv("TERM"), "256color") {
+		return Extended
+	}
+	return Basic
+}
+
+// Styler gives a stable style to each task.
 type Styler struct {
-	current int
+	Palette Palette
+}
+
+func NewStyler() *Styler {
+	return &Styler{Palette: DetectPalette()}
+}
+
+// For returns the style of a task: the same ID always gets the same color.
+func (s *Styler) For(id string) Style {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(id))
+	sum := h.Sum32()
+	switch s.Palette {
+	case TrueColor:
+		r, g, b := hslToRGB(float64(sum%360), 0.65, 0.6)
+		return Style{color.Attribute(foreground), color.Attribute(trueColor), color.Attribute(r), color.Attribute(g), color.Attribute(b), color.Bold}
+	case Extended:
+		c := extendedColors[sum%uint32(len(extendedColors))]
+		return Style{color.Attribute(foreground), color.Attribute(extended), color.Attribute(c), color.Bold}
+	default:
+		return Style{basicColors[sum%uint32(len(basicColors))], color.Bold}
+	}
+}
+
+// Parse a user defined task color: a name ("red", "hi-blue"), a 256 colors index ("208") or a hex value ("#ff8800").
+func (s *Styler) Parse(c string) (Style, error) {
+	c = strings.ToLower(strings.TrimSpace(c))
+	if c == "" {
+		return nil, nil
+	}
+	style, err := s.parseColor(c)
+	if err != nil {
+		return nil, err
+	}
+	return append(style, color.Bold), nil
+}
+
+// ParseStyle parses a list of colors and modifiers like "red bold" or "underline".
+func (s *Styler) ParseStyle(spec string) (Style, error) {
+	var style Style
+	for _, word := range strings.Fields(strings.ToLower(spec)) {
+		if attr, ok := modifiers[word]; ok {
+			style = append(style, attr)
+			continue
+		}
+		c, err := s.parseColor(word)
+		if err != nil {
+			return nil, err
+		}
+		style = append(style, c...)
+	}
+	return style, nil
+}
+
+var modifiers = map[string]color.Attribute{
+	"bold":      color.Bold,
+	"faint":     color.Faint,
+	"italic":    color.Italic,
+	"underline": color.Underline,
+	"blink":     color.BlinkSlow,
+	"reverse":   color.ReverseVideo,
+}
+
+func (s *Styler) parseColor(c string) (Style, error) {
+	if strings.HasPrefix(c, "#") {
+		v, err := strconv.ParseUint(c[1:], 16, 32)
+		if err != nil || len(c) != 7 {
+			return nil, fmt.Errorf("invalid hex color: %v", c)
+		}
+		r, g, b := int(v>>16), int(v>>8&0xff), int(v&0xff)
+		if s.Palette == TrueColor {
+			return Style{color.Attribute(foreground), color.Attribute(trueColor), color.Attribute(r), color.Attribute(g), color.Attribute(b)}, nil
+		}
+		return Style{color.Attribute(foreground), color.Attribute(extended), color.Attribute(rgbToExtended(r, g, b))}, nil
+	}
+	if i, err := strconv.Atoi(c); err == nil {
+		if i < 0 || i > 255 {
+			return nil, fmt.Errorf("invalid color index: %v", c)
+		}
+		return Style{color.Attribute(foreground), color.Attribute(extended), color.Attribute(i)}, nil
+	}
+	name := strings.TrimPrefix(strings.TrimPrefix(c, "hi-"), "bright-")
+	attr, ok := namedColors[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown color: %v", c)
+	}
+	if name != c {
+		attr += color.FgHiBlack - color.FgBlack
+	}
+	return Style{attr}, nil
+}
+
+// ValidColor checks a user defined color.
+func ValidColor(c string) error {
+	_, err := (&Styler{}).Parse(c)
+	return err
+}
+
+// ValidStyle checks a user defined style.
+func ValidStyle(spec string) error {
+	_, err := (&Styler{}).ParseStyle(spec)
+	return err
+}
+
+// Colorize wraps s with the style.
+func Colorize(s string, style Style) string {
+	var attributes []color.Attribute
+	for _, arg := range style {
+		if attr, ok := arg.(color.Attribute); ok {
+			attributes = append(attributes, attr)
+		}
+	}
+	if len(attributes) == 0 {
+		return s
+	}
+	return color.New(attributes...).Sprint(s)
+}
+
+// DisableColors turns off all colors, for --no-color.
+func DisableColors() {
+	color.NoColor = true
+}
+
+func rgbToExtended(r, g, b int) int {
+	scale := func(v int) int {
+		return int(math.Round(float64(v) / 255 * 5))
+	}
+	return 16 + 36*scale(r) + 6*scale(g) + scale(b)
 }
 
-func (s *Styler) Next() Style {
-	var attributes []interface{}
-	if s.current < 10 {
-		attributes = append(attributes, color.Attribute(BackgroundOffset+s.current))
+func hslToRGB(h, s, l float64) (int, int, int) {
+	c := (1 - math.Abs(2*l-1)) * s
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	m := l - c/2
+	var r, g, b float64
+	switch {
+	case h < 60:
+		r, g, b = c, x, 0
+	case h < 120:
+		r, g, b = x, c, 0
+	case h < 180:
+		r, g, b = 0, c, x
+	case h < 240:
+		r, g, b = 0, x, c
+	case h < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
 	}
-	attributes = append(attributes, color.Bold)
-	s.current++
-	return attributes
+	return int((r + m) * 255), int((g + m) * 255), int((b + m) * 255)
 }
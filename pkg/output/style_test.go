@@ -0,0 +1,52 @@
+package output_test
+
+import (
+	"testing"
+
+	"github.com/AntoineToussaint/kommence/pkg/output"
+	"github.com/fatih/color"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStyler(t *testing.T) {
+	for _, palette := range []output.Palette{output.Basic, output.Extended, output.TrueColor} {
+		styler := output.Styler{Palette: palette}
+		// Stable, whatever the order
+		api := styler.For("api")
+		styler.For("worker")
+		assert.Equal(t, api, styler.For("api"))
+		assert.Equal(t, api, (&output.Styler{Palette: palette}).For("api"))
+	}
+}
+
+func TestStylerParse(t *testing.T) {
+	styler := output.Styler{Palette: output.TrueColor}
+	s, err := styler.Parse("red")
+	assert.NoError(t, err)
+	assert.Equal(t, output.Style{color.FgRed, color.Bold}, s)
+
+	s, err = styler.Parse("hi-blue")
+	assert.NoError(t, err)
+	assert.Equal(t, output.Style{color.FgHiBlue, color.Bold}, s)
+
+	s, err = styler.Parse("208")
+	assert.NoError(t, err)
+	assert.Equal(t, output.Style{color.Attribute(38), color.Attribute(5), color.Attribute(208), color.Bold}, s)
+
+	s, err = styler.Parse("#ff8800")
+	assert.NoError(t, err)
+	assert.Equal(t, output.Style{color.Attribute(38), color.Attribute(2), color.Attribute(255), color.Attribute(136), color.Attribute(0), color.Bold}, s)
+
+	// Downgraded to the 256 colors cube
+	s, err = (&output.Styler{Palette: output.Extended}).Parse("#ff8800")
+	assert.NoError(t, err)
+	assert.Equal(t, output.Style{color.Attribute(38), color.Attribute(5), color.Attribute(214), color.Bold}, s)
+
+	s, err = styler.Parse("")
+	assert.NoError(t, err)
+	assert.Nil(t, s)
+
+	for _, invalid := range []string{"purple", "300", "#12345", "#zzzzzz"} {
+		assert.Error(t, output.ValidColor(invalid), invalid)
+	}
+}
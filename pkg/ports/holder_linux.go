@@ -0,0 +1,66 @@
+//go:build linux
+
+package ports
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// listen is the state of listening sockets in /proc/net/tcp.
+const listen = "0A"
+
+// Holder finds the process listening on the port from /proc/net/tcp.
+// Processes of other users can't be found without privileges.
+func Holder(port int) (Process, bool) {
+	inodes := make(map[string]bool)
+	for _, table := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		listening(table, port, inodes)
+	}
+	if len(inodes) == 0 {
+		return Process{}, false
+	}
+	fds, _ := filepath.Glob("/proc/[0-9]*/fd/*")
+	for _, fd := range fds {
+		link, err := os.Readlink(fd)
+		if err != nil || !strings.HasPrefix(link, "socket:[") {
+			continue
+		}
+		if !inodes[strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")] {
+			continue
+		}
+		dir := filepath.Dir(filepath.Dir(fd))
+		pid, _ := strconv.Atoi(filepath.Base(dir))
+		name, _ := os.ReadFile(filepath.Join(dir, "comm"))
+		return Process{PID: pid, Name: strings.TrimSpace(string(name))}, true
+	}
+	return Process{}, false
+}
+
+// listening adds the inodes of the sockets listening on the port.
+func listening(table string, port int, inodes map[string]bool) {
+	f, err := os.Open(table)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	// Skip the header
+	scanner.Scan()
+	for scanner.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || fields[3] != listen {
+			continue
+		}
+		i := strings.LastIndexByte(fields[1], ':')
+		p, err := strconv.ParseInt(fields[1][i+1:], 16, 32)
+		if err != nil || int(p) != port {
+			continue
+		}
+		inodes[fields[9]] = true
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package ports
+
+// Holder is only implemented on Linux.
+func Holder(port int) (Process, bool) {
+	return Process{}, false
+}
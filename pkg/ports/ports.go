@@ -0,0 +1,83 @@
+// Package ports checks the local ports used by tasks and allocates the automatic ones.
+package ports
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sync"
+)
+
+// Process holding a port.
+type Process struct {
+	PID  int
+	Name string
+}
+
+func (p Process) String() string {
+	return fmt.Sprintf("%v (pid %v)", p.Name, p.PID)
+}
+
+// Reservation holds a free port until the task it's allocated to binds it.
+type Reservation struct {
+	Port int
+
+	once     sync.Once
+	listener net.Listener
+}
+
+// Reserve a port nothing listens on. It's held until released, so that nothing else takes it meanwhile.
+func Reserve() (*Reservation, error) {
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		return nil, fmt.Errorf("can't allocate a port: %v", err)
+	}
+	return &Reservation{Port: l.Addr().(*net.TCPAddr).Port, listener: l}, nil
+}
+
+// Release the port for its task to bind it, right before it does. Releasing again does nothing.
+func (r *Reservation) Release() {
+	r.once.Do(func() {
+		_ = r.listener.Close()
+	})
+}
+
+// Check returns an error naming the process listening on the port, if any.
+func Check(port int) error {
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err == nil {
+		return l.Close()
+	}
+	if p, ok := Holder(port); ok {
+		return fmt.Errorf("port %v is used by %v", port, p)
+	}
+	return fmt.Errorf("port %v is not available: %v", port, err)
+}
+
+// Table of the ports of the tasks, by task and name.
+type Table map[string]map[string]int
+
+// Set the port of a task.
+func (t Table) Set(task string, name string, port int) {
+	if t[task] == nil {
+		t[task] = make(map[string]int)
+	}
+	t[task][name] = port
+}
+
+var reference = regexp.MustCompile(`\$\{ports\.([^.}]+)\.([^.}]+)\}`)
+
+// Interpolate replaces ${ports.<task>.<name>} with the port.
+func (t Table) Interpolate(s string) (string, error) {
+	var err error
+	s = reference.ReplaceAllStringFunc(s, func(ref string) string {
+		m := reference.FindStringSubmatch(ref)
+		port, ok := t[m[1]][m[2]]
+		if !ok {
+			err = fmt.Errorf("unknown port %v", ref)
+			return ref
+		}
+		return fmt.Sprint(port)
+	})
+	return s, err
+}
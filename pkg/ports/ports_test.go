@@ -0,0 +1,46 @@
+package ports_test
+
+import (
+	"net"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/AntoineToussaint/kommence/pkg/ports"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheck(t *testing.T) {
+	// Reserved until released
+	r, err := ports.Reserve()
+	assert.NoError(t, err)
+	assert.Error(t, ports.Check(r.Port))
+	r.Release()
+	r.Release()
+	assert.NoError(t, ports.Check(r.Port))
+
+	l, err := net.Listen("tcp", ":0")
+	assert.NoError(t, err)
+	defer l.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+	assert.Error(t, ports.Check(port))
+
+	if runtime.GOOS != "linux" {
+		return
+	}
+	p, ok := ports.Holder(port)
+	assert.True(t, ok)
+	assert.Equal(t, os.Getpid(), p.PID)
+	assert.NotEmpty(t, p.Name)
+}
+
+func TestInterpolate(t *testing.T) {
+	table := ports.Table{}
+	table.Set("api", "http", 8080)
+	s, err := table.Interpolate("--api=localhost:${ports.api.http} --port ${ports.api.http}")
+	assert.NoError(t, err)
+	assert.Equal(t, "--api=localhost:8080 --port 8080", s)
+
+	_, err = table.Interpolate("${ports.api.grpc}")
+	assert.Error(t, err)
+}
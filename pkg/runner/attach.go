@@ -0,0 +1,105 @@
+package runner
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// Attachable tasks accept input.
+type Attachable interface {
+	// Input returns the stdin of the task and true if it is a terminal
+	Input() (io.Writer, bool, error)
+}
+
+// maxPaused is how many lines of the other tasks are kept while attached.
+const maxPaused = 10000
+
+// attachment routes the output of a task to a client while the output of the other tasks is paused.
+type attachment struct {
+	id      string
+	out     chan<- string
+	paused  []string
+	dropped int
+}
+
+// Attach routes the output of a task to out and returns its input, true if it is a terminal, and a function to detach.
+// The output of the other tasks is paused until detached. Only one client can be attached at a time.
+func (r *Runner) Attach(name string, out chan<- string) (io.Writer, bool, func(), error) {
+	task := r.find(name)
+	if task == nil {
+		return nil, false, nil, fmt.Errorf("unknown task %v", name)
+	}
+	attachable, ok := task.(Attachable)
+	if !ok {
+		return nil, false, nil, fmt.Errorf("%v doesn't accept input", name)
+	}
+	in, tty, err := attachable.Input()
+	if err != nil {
+		return nil, false, nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.attached != nil {
+		return nil, false, nil, fmt.Errorf("already attached to %v", r.attached.id)
+	}
+	a := &attachment{id: task.ID(), out: out}
+	r.attached = a
+	r.Logger.Printf("attached to %v: the output of other tasks is paused\n", a.id, color.Bold)
+	return in, tty, func() { r.detach(a) }, nil
+}
+
+// detach prints the output paused while attached.
+func (r *Runner) detach(a *attachment) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.attached != a {
+		return
+	}
+	r.attached = nil
+	r.Logger.Printf("detached from %v\n", a.id, color.Bold)
+	if a.dropped > 0 {
+		r.Logger.Printf("%v paused lines dropped\n", a.dropped, color.Bold)
+	}
+	for _, line := range a.paused {
+		r.Logger.Printf("%s\n", line)
+	}
+}
+
+// print a rendered line of a task, or pause it if another task is attached.
+// The content is sent to the attached client.
+func (r *Runner) print(id string, content string, line string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if a := r.attached; a != nil {
+		// Without client, the output of the task is paused as well
+		if id != a.id || a.out == nil {
+			if len(a.paused) == maxPaused {
+				a.paused = a.paused[1:]
+				a.dropped++
+			}
+			a.paused = append(a.paused, line)
+			return
+		}
+		select {
+		case a.out <- content:
+		default:
+			// The client is too slow: it still has the session output
+		}
+	}
+	r.Logger.Printf("%s\n", line)
+}
+
+// find a task by ID or by the ID of its configuration.
+func (r *Runner) find(name string) Runnable {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, task := range r.tasks {
+		if task.ID() == name || strings.HasSuffix(task.ID(), " "+name) {
+			return task
+		}
+	}
+	return nil
+}
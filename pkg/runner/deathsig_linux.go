@@ -0,0 +1,11 @@
+//go:build linux
+
+package runner
+
+import "syscall"
+
+// dieWithKommence kills the process when kommence dies, even with SIGKILL.
+// Its children are not killed: the session state file covers them.
+func dieWithKommence(attrs *syscall.SysProcAttr) {
+	attrs.Pdeathsig = syscall.SIGKILL
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package runner
+
+import "syscall"
+
+// dieWithKommence is only supported on Linux: the session state file covers the other systems.
+func dieWithKommence(attrs *syscall.SysProcAttr) {}
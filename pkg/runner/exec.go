@@ -0,0 +1,247 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/AntoineToussaint/kommence/pkg/configuration"
+	"github.com/fatih/color"
+	"golang.org/x/term"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// DefaultShell is run when exec is given no command.
+const DefaultShell = "sh"
+
+// defaultContainerAnnotation chooses the container of kubectl exec and logs.
+const defaultContainerAnnotation = "kubectl.kubernetes.io/default-container"
+
+// Exec runs a command, a shell by default, in the container of the pod selected for a configuration.
+func Exec(ctx context.Context, kube Cluster, c *configuration.Pod, command []string, streams remotecommand.StreamOptions) error {
+	pod, err := SelectPod(ctx, kube.Client(), c)
+	if err != nil {
+		return err
+	}
+	if len(command) == 0 {
+		command = []string{DefaultShell}
+	}
+	// The terminal gets both outputs
+	if streams.Tty {
+		streams.Stderr = nil
+	}
+	executor, err := kube.Executor(*pod, &v1.PodExecOptions{
+		Container: ExecContainer(pod, c),
+		Command:   command,
+		Stdin:     streams.Stdin != nil,
+		Stdout:    streams.Stdout != nil,
+		Stderr:    streams.Stderr != nil,
+		TTY:       streams.Tty,
+	})
+	if err != nil {
+		return err
+	}
+	return executor.StreamWithContext(ctx, streams)
+}
+
+// ExecContainer returns the container to exec into: the one of the configuration,
+// the default one of the pod like kubectl, or its first one.
+func ExecContainer(pod *v1.Pod, c *configuration.Pod) string {
+	if c.Container != "" {
+		return c.Container
+	}
+	if name := pod.Annotations[defaultContainerAnnotation]; name != "" {
+		return name
+	}
+	if len(pod.Spec.Containers) > 0 {
+		return pod.Spec.Containers[0].Name
+	}
+	return ""
+}
+
+// TerminalStreams returns the streams of an exec attached to the terminal of kommence, with its input read from stdin.
+// If the terminal is one, it's in raw mode and its size follows the window until restored.
+func TerminalStreams(stdin io.Reader) (remotecommand.StreamOptions, func(), error) {
+	streams := remotecommand.StreamOptions{Stdin: stdin, Stdout: os.Stdout, Stderr: os.Stderr}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return streams, func() {}, nil
+	}
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return streams, nil, fmt.Errorf("can't read keystrokes: %v", err)
+	}
+	done := make(chan struct{})
+	streams.Tty = true
+	streams.TerminalSizeQueue = followWindowSize(done)
+	return streams, func() {
+		close(done)
+		_ = term.Restore(fd, state)
+	}, nil
+}
+
+// terminalSizes of the window of kommence, closed when done.
+type terminalSizes chan remotecommand.TerminalSize
+
+func (s terminalSizes) Next() *remotecommand.TerminalSize {
+	size, ok := <-s
+	if !ok {
+		return nil
+	}
+	return &size
+}
+
+// followWindowSize sends the size of the window of kommence, and its new size when resized, until done.
+func followWindowSize(done chan struct{}) terminalSizes {
+	sizes := make(terminalSizes)
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	go func() {
+		defer close(sizes)
+		defer signal.Stop(winch)
+		for {
+			size := windowSize()
+			select {
+			case sizes <- remotecommand.TerminalSize{Width: size.Cols, Height: size.Rows}:
+			case <-done:
+				return
+			}
+			select {
+			case <-winch:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return sizes
+}
+
+// Exec runs a command in the pod of the task, a shell by default.
+func (p *Pod) Exec(ctx context.Context, command []string, streams remotecommand.StreamOptions) error {
+	return Exec(ctx, p.kube, p.config, command, streams)
+}
+
+// Pods returns the IDs of the pod tasks of the session.
+func (r *Runner) Pods() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var pods []string
+	for _, task := range r.tasks {
+		if _, ok := task.(*Pod); ok {
+			pods = append(pods, task.ID())
+		}
+	}
+	return pods
+}
+
+// Exec runs a command in the pod of a task, attached to the terminal, with its input read from stdin.
+// The output of the session is paused until it exits.
+func (r *Runner) Exec(ctx context.Context, name string, command []string, stdin io.Reader) error {
+	pod, ok := r.find(name).(*Pod)
+	if !ok {
+		return fmt.Errorf("unknown pod %v", name)
+	}
+	if pod.kube == nil {
+		return fmt.Errorf("%v isn't connected to kubernetes", pod.ID())
+	}
+	r.mu.Lock()
+	if r.attached != nil {
+		r.mu.Unlock()
+		return fmt.Errorf("already attached to %v", r.attached.id)
+	}
+	// Nothing is sent to the terminal while exec uses it
+	a := &attachment{id: pod.ID()}
+	r.attached = a
+	r.mu.Unlock()
+	defer r.detach(a)
+
+	r.Logger.Printf("exec in %v: the output of the session is paused\n", pod.ID(), color.Bold)
+	streams, restore, err := TerminalStreams(stdin)
+	if err != nil {
+		return err
+	}
+	defer restore()
+	return pod.Exec(ctx, command, streams)
+}
+
+// Keys reads the keys typed in a terminal from a single goroutine, for the session or for an exec in turn.
+// An exec whose input is the terminal itself would keep reading it after it exits, and take the next key.
+type Keys struct {
+	keys chan byte
+}
+
+// ReadKeys from a terminal until it fails.
+func ReadKeys(terminal io.Reader) *Keys {
+	k := &Keys{keys: make(chan byte)}
+	go func() {
+		defer close(k.keys)
+		data := make([]byte, 256)
+		for {
+			n, err := terminal.Read(data)
+			for _, key := range data[:n] {
+				k.keys <- key
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return k
+}
+
+// Next key, false once the terminal can't be read.
+func (k *Keys) Next() (byte, bool) {
+	key, ok := <-k.keys
+	return key, ok
+}
+
+// Input returns a reader of the next keys until stopped: a blocked read then returns io.EOF.
+func (k *Keys) Input() (io.Reader, func()) {
+	in := keysInput{keys: k.keys, done: make(chan struct{})}
+	return in, func() { close(in.done) }
+}
+
+type keysInput struct {
+	keys <-chan byte
+	done chan struct{}
+}
+
+func (in keysInput) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	// Once stopped, the keys are for the session
+	select {
+	case <-in.done:
+		return 0, io.EOF
+	default:
+	}
+	select {
+	case key, ok := <-in.keys:
+		if !ok {
+			return 0, io.EOF
+		}
+		p[0] = key
+	case <-in.done:
+		return 0, io.EOF
+	}
+	// And what was typed or pasted with it
+	n := 1
+	for n < len(p) {
+		select {
+		case key, ok := <-in.keys:
+			if !ok {
+				return n, nil
+			}
+			p[n] = key
+			n++
+		default:
+			return n, nil
+		}
+	}
+	return n, nil
+}
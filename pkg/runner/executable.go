@@ -1,21 +1,22 @@
 package runner
 
 import (
-	"bufio"
-	"bytes"
 	"context"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"os/exec"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/AntoineToussaint/kommence/pkg/configuration"
 	"github.com/AntoineToussaint/kommence/pkg/output"
-	"github.com/radovskyb/watcher"
+	"github.com/AntoineToussaint/kommence/pkg/ports"
+	"github.com/AntoineToussaint/kommence/pkg/watch"
+	"github.com/creack/pty"
 )
 
 type Executable struct {
@@ -28,9 +29,33 @@ type Executable struct {
 	logger      *output.Logger
 	config      *configuration.Executable
 	restartChan chan interface{}
+	limiter     *limiter
+	done        chan struct{}
+	// reserved ports, released when the process starts
+	reserved []*ports.Reservation
+
+	// mu serializes builds and restarts
+	mu       sync.Mutex
+	buildMu  sync.Mutex
+	building *exec.Cmd
+	// started once the before_start hooks succeeded: stop hooks only run then
+	started atomic.Bool
+	// stopped prevents pending restarts
+	stopped atomic.Bool
+	// killed is the last command killed by kommence: its exit isn't reported
+	killed atomic.Pointer[exec.Cmd]
+	// failures of a job stopping kommence
+	failures chan error
+
+	inputMu sync.Mutex
+	input   io.Writer
 }
 
 func NewExecutable(logger *output.Logger, c *configuration.Executable) Runnable {
+	return newExecutable(logger, c)
+}
+
+func newExecutable(logger *output.Logger, c *configuration.Executable) *Executable {
 	args := strings.Split(c.Cmd, " ")
 	return &Executable{
 		logger:     logger,
@@ -39,6 +64,7 @@ func NewExecutable(logger *output.Logger, c *configuration.Executable) Runnable
 		cmd:        args[0],
 		args:       args[1:],
 		stdErrMode: c.StdErr,
+		failures:   make(chan error, 1),
 	}
 }
 
@@ -47,23 +73,47 @@ func (e *Executable) ID() string {
 }
 
 func (e *Executable) Start(ctx context.Context, rec chan output.Message) error {
-	e.logger.Debugf("creating watcher: %v\n", e.ID())
-	w := e.createWatcher()
+	if err := runHooks(ctx, rec, e.ID(), "before_start", e.config.BeforeStart, e.prepare); err != nil {
+		rec <- output.Message{ID: e.ID(), Type: output.Blocked, Content: fmt.Sprintf("not starting: %v", err)}
+		return nil
+	}
+	e.started.Store(true)
+	if e.config.Limits.IsSet() {
+		var warnings []string
+		e.limiter, warnings = newLimiter(e.config.ID, e.config.Limits)
+		for _, warning := range warnings {
+			rec <- output.Message{ID: e.ID(), Type: output.Error, Content: warning}
+		}
+	}
+	var changes <-chan []string
+	var errors <-chan error
+	if len(e.config.Watch) > 0 {
+		e.logger.Debugf("creating watcher: %v\n", e.ID())
+		w, err := e.createWatcher(rec)
+		if err != nil {
+			rec <- output.Message{ID: e.ID(), Type: output.Error, Content: fmt.Sprintf("can't watch: %v", err)}
+		} else {
+			defer w.Close()
+			changes, errors = w.Changes, w.Errors
+		}
+	}
 	go func() {
-		e.logger.Debugf("start: %v\n", e.ID())
-		e.start(ctx, rec)
+		if !e.first(ctx, rec) {
+			return
+		}
+		if err := runHooks(ctx, rec, e.ID(), "after_start", e.config.AfterStart, e.prepare); err != nil {
+			rec <- output.Message{ID: e.ID(), Type: output.Error, Content: err.Error()}
+		}
 	}()
 	for {
 		select {
-		case <-w.Event:
-			e.logger.Debugf("watcher caused restart: %v\n", e.ID())
+		case changed := <-changes:
+			e.logger.Debugf("watcher caused restart: %v: %v\n", e.ID(), changed)
 			go e.restart(ctx, rec)
-		case err := <-w.Error:
-			e.logger.Errorf("watcher error: %v: %v\n", e.ID(), err)
-			return err
-		case <-w.Closed:
-			e.logger.Debugf("watcher closed: %v\n", e.ID())
-			return nil
+		case err := <-errors:
+			rec <- output.Message{ID: e.ID(), Type: output.Error, Content: fmt.Sprintf("watcher error: %v", err)}
+		case err := <-e.failures:
+			return fmt.Errorf("job %v failed: %v", e.config.ID, err)
 		case <-ctx.Done():
 			return nil
 		}
@@ -72,44 +122,102 @@ func (e *Executable) Start(ctx context.Context, rec chan output.Message) error {
 
 func (e *Executable) Stop(ctx context.Context, rec chan output.Message) error {
 	e.logger.Debugf("stopping: %v\n", e.ID())
-	return e.kill(ctx, rec)
+	// The context is usually done already: hooks only get their timeout
+	hooks := e.started.Load()
+	if hooks {
+		if err := runHooks(context.Background(), rec, e.ID(), "before_stop", e.config.BeforeStop, e.prepare); err != nil {
+			rec <- output.Message{ID: e.ID(), Type: output.Error, Content: err.Error()}
+		}
+	}
+	e.stopped.Store(true)
+	e.buildMu.Lock()
+	if e.building != nil && e.building.Process != nil {
+		_ = syscall.Kill(-e.building.Process.Pid, syscall.SIGKILL)
+	}
+	e.buildMu.Unlock()
+	e.mu.Lock()
+	err := e.kill(ctx, rec)
+	done := e.done
+	e.mu.Unlock()
+	// The cgroup can only be removed and after_stop hooks run once the process is gone
+	if done != nil && (e.limiter != nil || len(e.config.AfterStop) > 0) {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+		}
+	}
+	if e.limiter != nil {
+		if err := e.limiter.Close(); err != nil {
+			e.logger.Debugf("can't remove cgroup of %v: %v\n", e.ID(), err)
+		}
+	}
+	if hooks {
+		if err := runHooks(context.Background(), rec, e.ID(), "after_stop", e.config.AfterStop, e.prepare); err != nil {
+			rec <- output.Message{ID: e.ID(), Type: output.Error, Content: err.Error()}
+		}
+	}
+	return err
 }
 
-func (e *Executable) createWatcher() *watcher.Watcher {
-	//out := make(chan bool)
-	w := watcher.New()
+// Input of the running process, following restarts, and true if it is a terminal.
+func (e *Executable) Input() (io.Writer, bool, error) {
+	if !e.config.Stdin && !e.config.TTY {
+		return nil, false, fmt.Errorf("%v doesn't accept input: set stdin: true", e.config.ID)
+	}
+	return inputWriter{e}, e.config.TTY, nil
+}
 
-	// SetMaxEvents to 1 to allow at most 1 event's to be received
-	// on the Event channel per watching cycle.
-	//
-	// If SetMaxEvents is not set, the default is to send all events.
-	w.SetMaxEvents(1)
+func (e *Executable) setInput(w io.Writer) {
+	e.inputMu.Lock()
+	defer e.inputMu.Unlock()
+	e.input = w
+}
 
-	// Only notify rename and move events.
-	w.FilterOps(watcher.Write)
+type inputWriter struct {
+	e *Executable
+}
 
-	// Only files that match the regular expression during file listings
-	// will be watched.
-	//r := regexp.MustCompile("^abc$")
-	//w.AddFilterHook(watcher.Executables.RegexFilterHook(r, false))
+func (w inputWriter) Write(p []byte) (int, error) {
+	w.e.inputMu.Lock()
+	input := w.e.input
+	w.e.inputMu.Unlock()
+	if input == nil {
+		return 0, fmt.Errorf("%v is not running", w.e.config.ID)
+	}
+	return input.Write(p)
+}
 
-	// Watch this folder for changes.
-	for _, p := range e.config.Watch {
-		if err := w.AddRecursive(p); err != nil {
-			log.Fatalln(err)
-		}
+// first builds and starts the process, returns false if it didn't start.
+func (e *Executable) first(ctx context.Context, rec chan output.Message) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.stopped.Load() || !e.build(ctx, rec) {
+		return false
 	}
+	e.logger.Debugf("start: %v\n", e.ID())
+	return e.start(ctx, rec)
+}
 
-	go func() {
-		// Start the watching process - it'll check for changes every 100ms.
-		if err := w.Start(time.Millisecond * 100); err != nil {
-			log.Fatalln(err)
-		}
-	}()
-	return w
+func (e *Executable) createWatcher(rec chan output.Message) (*watch.Watcher, error) {
+	w, warnings, err := watch.New(watch.Config{
+		Dir:       ".",
+		Watch:     e.config.Watch,
+		Ignore:    e.config.Ignore,
+		GitIgnore: e.config.UseGitIgnore(),
+		Debounce:  e.config.GetDebounce(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, warning := range warnings {
+		rec <- output.Message{ID: e.ID(), Type: output.Error, Content: warning}
+	}
+	w.Start()
+	return w, nil
 }
 
-func (e *Executable) start(ctx context.Context, rec chan output.Message) {
+// start the process, returns false if it can't.
+func (e *Executable) start(ctx context.Context, rec chan output.Message) bool {
 	if e.config.Delay != "" {
 		e.logger.Debugf("delaying %v %v\n", e.ID(), e.config.Delay)
 		d, _ := time.ParseDuration(e.config.Delay)
@@ -117,37 +225,205 @@ func (e *Executable) start(ctx context.Context, rec chan output.Message) {
 	}
 	e.logger.Debugf("starting %v\n", e.ID())
 	e.command = exec.CommandContext(ctx, e.cmd, e.args...)
-	if fi, err := os.Stat(e.path); err == nil && fi.IsDir() {
-		e.command.Dir = e.path
-	}
-	e.command.Env = os.Environ()
-	for k, v := range e.config.Env {
-		e.command.Env = append(e.command.Env, fmt.Sprintf("%s=%s", k, v))
+	e.prepare(e.command)
+	if e.limiter != nil {
+		if err := e.limiter.Wrap(e.command); err != nil {
+			rec <- output.Message{ID: e.ID(), Type: output.Error, Content: err.Error()}
+		}
 	}
 
-	// Request the OS to assign process group to the new process, to which all its children will belong
-	e.command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	var stdout, stderr io.ReadCloser
+	var tty *os.File
+	release(e.reserved)
+	if e.config.TTY {
+		// A new session controlled by the terminal: it is also a new process group
+		attrs := &syscall.SysProcAttr{Setsid: true, Setctty: true}
+		dieWithKommence(attrs)
+		if e.limiter != nil {
+			e.limiter.Prepare(attrs)
+		}
+		var err error
+		if tty, err = pty.StartWithAttrs(e.command, windowSize(), attrs); err != nil {
+			e.notStarted(rec, err)
+			return false
+		}
+		// The terminal merges stdout and stderr
+		stdout = tty
+		e.setInput(tty)
+	} else {
+		// Request the OS to assign process group to the new process, to which all its children will belong
+		e.command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		dieWithKommence(e.command.SysProcAttr)
+		if e.limiter != nil {
+			e.limiter.Prepare(e.command.SysProcAttr)
+		}
 
-	stdout, _ := e.command.StdoutPipe()
-	stderr, _ := e.command.StderrPipe()
+		stdout, _ = e.command.StdoutPipe()
+		stderr, _ = e.command.StderrPipe()
+		var stdin io.WriteCloser
+		if e.config.Stdin {
+			stdin, _ = e.command.StdinPipe()
+		}
 
-	if err := e.command.Start(); err != nil {
-		e.logger.Errorf("can't start %v: %v", e.ID(), err)
+		if err := e.command.Start(); err != nil {
+			e.notStarted(rec, err)
+			return false
+		}
+		if stdin != nil {
+			e.setInput(stdin)
+		}
 	}
-	// Export resources
+	started := time.Now()
+	cmd := e.command
+	recorder.Load().Add(e.ID(), cmd.Process.Pid)
+
+	// Export logs
+	var logs sync.WaitGroup
+	logs.Add(1)
 	go func() {
-		// TODO Doesn't work on MAC
-		//e.MonitorMemory(e.command.Process.Pid, rec)
+		defer logs.Done()
+		exportLines(output.NewLineBreaker(rec, e.ID(), output.Log), stdout)
 	}()
+	if stderr != nil && e.stdErrMode == configuration.AsLog {
+		logs.Add(1)
+		go func() {
+			defer logs.Done()
+			exportLines(output.NewLineBreaker(rec, e.ID(), output.Log), stderr)
+		}()
+	}
 
-	// Export logs
+	// Reap the process once all its output is read
+	done := make(chan struct{})
+	e.done = done
 	go func() {
-		_, _ = io.Copy(output.NewLineBreaker(rec, e.ID(), output.Log), stdout)
+		logs.Wait()
+		err := cmd.Wait()
+		recorder.Load().Remove(cmd.Process.Pid)
+		if tty != nil {
+			_ = tty.Close()
+		}
+		e.reportLimits(rec)
+		if e.config.IsJob() && e.killed.Load() != cmd {
+			e.complete(rec, cmd, err, time.Since(started))
+		}
+		close(done)
 	}()
-	if e.stdErrMode == configuration.AsLog {
-		_, _ = io.Copy(output.NewLineBreaker(rec, e.ID(), output.Log), stderr)
+
+	if tty != nil {
+		go resize(tty, done)
+	}
+
+	// Export resources
+	if !e.config.Monitor.Disabled {
+		go e.monitor(cmd.Process.Pid, rec, done)
+	}
+	return true
+}
+
+// prepare sets the directory and the environment of a command.
+func (e *Executable) prepare(cmd *exec.Cmd) {
+	if fi, err := os.Stat(e.path); err == nil && fi.IsDir() {
+		cmd.Dir = e.path
+	}
+	cmd.Env = os.Environ()
+	for k, v := range e.config.Env {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
 	}
-	return
+}
+
+// build runs the build command, if any, and returns true if the process can be (re)started.
+func (e *Executable) build(ctx context.Context, rec chan output.Message) bool {
+	if e.config.Build == "" {
+		return true
+	}
+	e.logger.Debugf("building %v\n", e.ID())
+	args := strings.Split(e.config.Build, " ")
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	e.prepare(cmd)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	dieWithKommence(cmd.SysProcAttr)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		rec <- output.Message{ID: e.ID(), Type: output.BuildFailed, Content: fmt.Sprintf("can't build: %v", err)}
+		return false
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		rec <- output.Message{ID: e.ID(), Type: output.BuildFailed, Content: fmt.Sprintf("can't build: %v", err)}
+		return false
+	}
+
+	started := time.Now()
+	e.buildMu.Lock()
+	err = cmd.Start()
+	if err == nil {
+		e.building = cmd
+	}
+	e.buildMu.Unlock()
+	if err != nil {
+		rec <- output.Message{ID: e.ID(), Type: output.BuildFailed, Content: fmt.Sprintf("can't build: %v", err)}
+		return false
+	}
+	recorder.Load().Add(e.ID(), cmd.Process.Pid)
+	// Each output has its own lines, read before waiting
+	var logs sync.WaitGroup
+	logs.Add(1)
+	go func() {
+		defer logs.Done()
+		exportLines(output.NewLineBreaker(rec, e.ID(), output.BuildError), stderr)
+	}()
+	exportLines(output.NewLineBreaker(rec, e.ID(), output.Build), stdout)
+	logs.Wait()
+	err = cmd.Wait()
+	recorder.Load().Remove(cmd.Process.Pid)
+	e.buildMu.Lock()
+	e.building = nil
+	e.buildMu.Unlock()
+
+	elapsed := time.Since(started).Round(time.Millisecond)
+	if err != nil {
+		rec <- output.Message{ID: e.ID(), Type: output.BuildFailed, Content: fmt.Sprintf("build failed after %v: %v", elapsed, err)}
+		return false
+	}
+	rec <- output.Message{ID: e.ID(), Type: output.Built, Content: fmt.Sprintf("built in %v", elapsed)}
+	return true
+}
+
+// notStarted reports a process that can't start as failed, with the exit code of shells.
+func (e *Executable) notStarted(rec chan output.Message, err error) {
+	// Nothing to wait for
+	done := make(chan struct{})
+	close(done)
+	e.done = done
+	code, reason := notStarted(e.cmd, err)
+	e.fail(rec, fmt.Sprintf("can't start: %v", reason), code, err)
+}
+
+// complete reports the exit of a job.
+func (e *Executable) complete(rec chan output.Message, cmd *exec.Cmd, err error, elapsed time.Duration) {
+	elapsed = elapsed.Round(time.Millisecond)
+	if err == nil {
+		rec <- output.Message{ID: e.ID(), Type: output.Completed, Content: fmt.Sprintf("completed in %v", elapsed)}
+		return
+	}
+	e.fail(rec, fmt.Sprintf("failed after %v: %v", elapsed, err), cmd.ProcessState.ExitCode(), err)
+}
+
+// fail reports a failure, which stops kommence for a job that requires it.
+func (e *Executable) fail(rec chan output.Message, content string, code int, err error) {
+	rec <- output.Message{ID: e.ID(), Type: output.Failed, Content: content, ExitCode: code}
+	if e.config.IsJob() && e.config.StopOnFailure {
+		select {
+		case e.failures <- err:
+		default:
+		}
+	}
+}
+
+// exportLines copies r into the LineBreaker and flushes the last partial line.
+func exportLines(w *output.LineBreaker, r io.Reader) {
+	_, _ = io.Copy(w, r)
+	_ = w.Close()
 }
 
 func (e *Executable) kill(ctx context.Context, rec chan output.Message) error {
@@ -155,60 +431,81 @@ func (e *Executable) kill(ctx context.Context, rec chan output.Message) error {
 	if e.command == nil || e.command.Process == nil {
 		return nil
 	}
-	if err := syscall.Kill(-e.command.Process.Pid, syscall.SIGKILL); err != nil {
+	e.killed.Store(e.command)
+	// The process group may already be gone
+	if err := syscall.Kill(-e.command.Process.Pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
 		e.logger.Errorf("failed to kill process %v: %v\n", e.ID(), err)
 		return err
 	}
 	return nil
 }
 
-func (e *Executable) MonitorMemory(pid int, rec chan output.Message) {
-	ticker := time.NewTicker(500 * time.Millisecond)
+// monitor samples the resources of the process group until the process is done.
+func (e *Executable) monitor(pgid int, rec chan output.Message, done chan struct{}) {
+	ticker := time.NewTicker(e.config.Monitor.GetInterval())
+	defer ticker.Stop()
+	s := newSampler(pgid)
+	memoryThreshold := e.config.Monitor.GetMemory()
+	cpuThreshold := e.config.Monitor.CPU
+	var memoryWarned, cpuWarned bool
 	for {
 		select {
+		case <-done:
+			return
 		case <-ticker.C:
-			mem, err := calculateMemory(pid)
+			res, err := s.Sample()
 			if err != nil {
-				e.logger.Errorf("can't calc memory for %v: %v", e.ID(), err)
+				e.logger.Debugf("can't sample resources for %v: %v\n", e.ID(), err)
 				continue
 			}
-			rec <- output.Message{
-				ID:      e.ID(),
-				Type:    output.Memory,
-				Content: fmt.Sprintf("%v", mem),
+			select {
+			case rec <- output.Message{ID: e.ID(), Type: output.Memory, Content: output.FormatBytes(res.Memory), Resources: &res}:
+			case <-done:
+				return
+			}
+			select {
+			case rec <- output.Message{ID: e.ID(), Type: output.CPU, Content: fmt.Sprintf("%.1f%%", res.CPU), Resources: &res}:
+			case <-done:
+				return
+			}
+			e.reportLimits(rec)
+			// Warn once each time a threshold is crossed
+			if memoryThreshold > 0 && (res.Memory > memoryThreshold) != memoryWarned {
+				memoryWarned = !memoryWarned
+				if memoryWarned {
+					rec <- output.Message{ID: e.ID(), Type: output.Error, Content: fmt.Sprintf("⚠️ memory %v above %v", output.FormatBytes(res.Memory), output.FormatBytes(memoryThreshold))}
+				}
+			}
+			if cpuThreshold > 0 && (res.CPU > cpuThreshold) != cpuWarned {
+				cpuWarned = !cpuWarned
+				if cpuWarned {
+					rec <- output.Message{ID: e.ID(), Type: output.Error, Content: fmt.Sprintf("⚠️ cpu %.1f%% above %.1f%%", res.CPU, cpuThreshold)}
+				}
 			}
 		}
 	}
 }
 
-func calculateMemory(pid int) (uint64, error) {
-	f, err := os.Open(fmt.Sprintf("/proc/%d/smaps", pid))
-	if err != nil {
-		return 0, err
-	}
-	defer f.Close()
-
-	res := uint64(0)
-	pfx := []byte("Pss:")
-	r := bufio.NewScanner(f)
-	for r.Scan() {
-		line := r.Bytes()
-		if bytes.HasPrefix(line, pfx) {
-			var size uint64
-			_, err := fmt.Sscanf(string(line[4:]), "%d", &size)
-			if err != nil {
-				return 0, err
-			}
-			res += size
-		}
+// reportLimits sends OOM kills and throttling.
+func (e *Executable) reportLimits(rec chan output.Message) {
+	if e.limiter == nil {
+		return
 	}
-	if err := r.Err(); err != nil {
-		return 0, err
+	for _, event := range e.limiter.Events() {
+		rec <- output.Message{ID: e.ID(), Type: output.Error, Content: event}
 	}
-	return res, nil
 }
 
 func (e *Executable) restart(ctx context.Context, rec chan output.Message) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.stopped.Load() {
+		return
+	}
+	// Keep the current process if the build fails
+	if !e.build(ctx, rec) {
+		return
+	}
 	err := e.kill(ctx, rec)
 	if err != nil {
 		e.logger.Errorf("can't kill %v: %v\n", e.ID(), err)
@@ -8,6 +8,8 @@ import (
 	"github.com/stretchr/testify/assert"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
 	"time"
 )
@@ -62,3 +64,227 @@ func TestSimpleExecutable(t *testing.T) {
 	assert.Equal(t, output.Stop, (<-rec).Type)
 
 }
+
+func TestExecutableMonitor(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("resource monitoring is only supported on Linux")
+	}
+	log := output.NewLogger(true)
+	config := configuration.Executable{ID: "X", Cmd: "sleep 5", Monitor: configuration.Monitor{Interval: "100ms", Memory: "1Ki"}}
+	exec := runner.NewExecutable(log, &config)
+
+	rec := make(chan output.Message, 8)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go exec.Start(ctx, rec)
+
+	memory := <-rec
+	assert.Equal(t, output.Memory, memory.Type)
+	assert.NotZero(t, memory.Resources.Memory)
+	assert.Equal(t, 1, memory.Resources.Threads)
+	assert.NotZero(t, memory.Resources.FDs)
+	assert.Equal(t, output.CPU, (<-rec).Type)
+	// Above the threshold
+	warning := <-rec
+	assert.Equal(t, output.Error, warning.Type)
+	assert.Contains(t, warning.Content, "memory")
+
+	go exec.Stop(ctx, rec)
+	for msg := range rec {
+		if msg.Type == output.Stop {
+			break
+		}
+	}
+}
+
+func TestExecutableLimits(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("resource limits are only supported on Linux")
+	}
+	script := filepath.Join(t.TempDir(), "limits.sh")
+	assert.NoError(t, os.WriteFile(script, []byte("ulimit -n\n"), 0755))
+
+	log := output.NewLogger(true)
+	config := configuration.Executable{ID: "X", Cmd: "sh " + script, Limits: configuration.Limits{OpenFiles: 64}}
+	exec := runner.NewExecutable(log, &config)
+
+	rec := make(chan output.Message, 8)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go exec.Start(ctx, rec)
+
+	assert.Equal(t, "64", (<-rec).Content)
+}
+
+func TestExecutableMemoryLimit(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("resource limits are only supported on Linux")
+	}
+	// The limit of the cgroup of the process if there is one, otherwise the rlimit
+	script := filepath.Join(t.TempDir(), "memory.sh")
+	assert.NoError(t, os.WriteFile(script, []byte(`cat "/sys/fs/cgroup$(sed -n 's/^0:://p' /proc/self/cgroup)/memory.max" 2>/dev/null || ulimit -v`+"\n"), 0755))
+
+	log := output.NewLogger(true)
+	config := configuration.Executable{ID: "X", Cmd: "sh " + script, Limits: configuration.Limits{Memory: "64Mi"}, Monitor: configuration.Monitor{Disabled: true}}
+	exec := runner.NewExecutable(log, &config)
+
+	rec := make(chan output.Message, 8)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go exec.Start(ctx, rec)
+
+	for msg := range rec {
+		// Warnings come first
+		if msg.Type == output.Log {
+			assert.Contains(t, []string{"67108864", "65536"}, msg.Content)
+			break
+		}
+	}
+	assert.NoError(t, exec.Stop(ctx, rec))
+}
+
+func TestExecutableBuild(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "main.go")
+	assert.NoError(t, os.WriteFile(source, []byte("package main"), 0644))
+	// The build fails when the "broken" file exists
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "build.sh"), []byte("printf compil\necho warning >&2\necho ing\ntest ! -f broken\n"), 0755))
+
+	log := output.NewLogger(true)
+	config := configuration.Executable{ID: "X", Cmd: "echo world", Build: "sh build.sh", Path: dir, Watch: []string{source}, Monitor: configuration.Monitor{Disabled: true}}
+	exec := runner.NewExecutable(log, &config)
+
+	rec := make(chan output.Message, 8)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go exec.Start(ctx, rec)
+
+	// The lines of stdout and stderr don't mix
+	assert.ElementsMatch(t, []output.Message{
+		{ID: exec.ID(), Type: output.Build, Content: "compiling"},
+		{ID: exec.ID(), Type: output.BuildError, Content: "warning"},
+	}, []output.Message{<-rec, <-rec})
+	assert.Equal(t, output.Built, (<-rec).Type)
+	assert.Equal(t, output.Message{ID: exec.ID(), Type: output.Log, Content: "world"}, <-rec)
+
+	// A failed build doesn't stop the process
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "broken"), nil, 0644))
+	assert.NoError(t, os.WriteFile(source, []byte("package main // broken"), 0644))
+	assert.ElementsMatch(t, []output.MessageType{output.Build, output.BuildError}, []output.MessageType{(<-rec).Type, (<-rec).Type})
+	failed := <-rec
+	assert.Equal(t, output.BuildFailed, failed.Type)
+	assert.Contains(t, failed.Content, "exit status 1")
+	select {
+	case msg := <-rec:
+		t.Fatalf("unexpected message: %v", msg)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	// A successful build restarts it
+	assert.NoError(t, os.Remove(filepath.Join(dir, "broken")))
+	assert.NoError(t, os.WriteFile(source, []byte("package main // fixed"), 0644))
+	assert.ElementsMatch(t, []output.MessageType{output.Build, output.BuildError}, []output.MessageType{(<-rec).Type, (<-rec).Type})
+	assert.Equal(t, output.Built, (<-rec).Type)
+	assert.Equal(t, output.Stop, (<-rec).Type)
+	assert.Equal(t, output.Restart, (<-rec).Type)
+	assert.Equal(t, "world", (<-rec).Content)
+}
+
+func TestExecutableHooks(t *testing.T) {
+	log := output.NewLogger(true)
+	config := configuration.Executable{ID: "X", Cmd: "sleep 5", Monitor: configuration.Monitor{Disabled: true}, Hooks: configuration.Hooks{
+		BeforeStart: []configuration.Hook{{Cmd: "echo before_start"}},
+		AfterStart:  []configuration.Hook{{Cmd: "echo after_start"}},
+		BeforeStop:  []configuration.Hook{{Cmd: "echo before_stop"}},
+		AfterStop:   []configuration.Hook{{Cmd: "echo after_stop"}},
+	}}
+	exec := runner.NewExecutable(log, &config)
+
+	rec := make(chan output.Message, 8)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go exec.Start(ctx, rec)
+	assert.Equal(t, "before_start", (<-rec).Content)
+	assert.Equal(t, "after_start", (<-rec).Content)
+
+	assert.NoError(t, exec.Stop(ctx, rec))
+	assert.Equal(t, "before_stop", (<-rec).Content)
+	assert.Equal(t, output.Stop, (<-rec).Type)
+	assert.Equal(t, "after_stop", (<-rec).Content)
+}
+
+func TestExecutableFailingHook(t *testing.T) {
+	log := output.NewLogger(true)
+	config := configuration.Executable{ID: "X", Cmd: "echo world", Hooks: configuration.Hooks{
+		BeforeStart: []configuration.Hook{{Cmd: "sleep 5", Timeout: "100ms"}},
+		AfterStop:   []configuration.Hook{{Cmd: "echo after_stop"}},
+	}}
+	exec := runner.NewExecutable(log, &config)
+
+	rec := make(chan output.Message, 8)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.NoError(t, exec.Start(ctx, rec))
+	blocked := <-rec
+	assert.Equal(t, output.Blocked, blocked.Type)
+	assert.Equal(t, `not starting: before_start hook "sleep 5" failed: timed out after 100ms`, blocked.Content)
+
+	// Never started: no stop hooks
+	assert.NoError(t, exec.Stop(ctx, rec))
+	assert.Equal(t, output.Stop, (<-rec).Type)
+	assert.Empty(t, rec)
+}
+
+func TestExecutableJob(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "job.sh")
+	assert.NoError(t, os.WriteFile(script, []byte("echo migrating\nexit 3\n"), 0755))
+
+	log := output.NewLogger(true)
+	config := configuration.Executable{ID: "X", Kind: configuration.Job, Cmd: "sh " + script, Monitor: configuration.Monitor{Disabled: true}}
+	exec := runner.NewExecutable(log, &config)
+
+	rec := make(chan output.Message, 8)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go exec.Start(ctx, rec)
+	assert.Equal(t, "migrating", (<-rec).Content)
+	failed := <-rec
+	assert.Equal(t, output.Failed, failed.Type)
+	assert.Equal(t, 3, failed.ExitCode)
+
+	// Stopping a job after it ran doesn't report anything else
+	assert.NoError(t, exec.Stop(ctx, rec))
+	assert.Equal(t, output.Stop, (<-rec).Type)
+	assert.Empty(t, rec)
+}
+
+func TestExecutableJobStopOnFailure(t *testing.T) {
+	log := output.NewLogger(true)
+	config := configuration.Executable{ID: "X", Kind: configuration.Job, Cmd: "false", StopOnFailure: true, Monitor: configuration.Monitor{Disabled: true}}
+	exec := runner.NewExecutable(log, &config)
+
+	rec := make(chan output.Message, 8)
+	err := exec.Start(context.Background(), rec)
+	assert.EqualError(t, err, "job X failed: exit status 1")
+	assert.Equal(t, output.Failed, (<-rec).Type)
+}
+
+func TestExecutableTTY(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "tty.sh")
+	assert.NoError(t, os.WriteFile(script, []byte("test -t 1 && echo terminal\nstty size\nprintf '\\033[31mred\\033[0m\\n' >&2\n"), 0755))
+
+	log := output.NewLogger(true)
+	config := configuration.Executable{ID: "X", Cmd: "sh " + script, TTY: true, Monitor: configuration.Monitor{Disabled: true}}
+	exec := runner.NewExecutable(log, &config)
+
+	rec := make(chan output.Message, 8)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go exec.Start(ctx, rec)
+	assert.Equal(t, "terminal", (<-rec).Content)
+	// Without a terminal, the default size
+	assert.Equal(t, "24 80", (<-rec).Content)
+	// Colors are kept in the messages, stderr goes to the terminal too
+	assert.Equal(t, "\x1b[31mred\x1b[0m", (<-rec).Content)
+	assert.NoError(t, exec.Stop(ctx, rec))
+}
@@ -0,0 +1,178 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"github.com/AntoineToussaint/kommence/pkg/configuration"
+	"github.com/AntoineToussaint/kommence/pkg/output"
+	"github.com/creack/pty"
+	"golang.org/x/term"
+)
+
+// Foreground runs an executable once, attached to the terminal, and returns its exit code.
+// Its hooks, build step and limits apply as well, with their output printed as is.
+// The before_stop hooks run when kommence is stopped with SIGTERM, before the executable is.
+// Its ports are checked and allocated like in a session, where the fixed ports of the other tasks can be referenced.
+func Foreground(ctx context.Context, logger *output.Logger, config *configuration.Configuration, c *configuration.Executable) (int, error) {
+	table, reserved, conflicts := allocatePorts(config, map[string]configuration.Ports{c.ID: c.Ports})
+	defer release(reserved[c.ID])
+	c, err := withPorts(c, table)
+	if err == nil {
+		err = conflicts[c.ID]
+	}
+	if err != nil {
+		return 0, fmt.Errorf("not starting %v: %v", c.ID, err)
+	}
+	e := newExecutable(logger, c)
+	e.reserved = reserved[c.ID]
+	rec := make(chan output.Message)
+	printed := make(chan struct{})
+	go func() {
+		defer close(printed)
+		for msg := range rec {
+			switch msg.Type {
+			case output.Error, output.BuildError, output.BuildFailed:
+				fmt.Fprintln(os.Stderr, msg.Content)
+			case output.Log, output.Build, output.Built:
+				fmt.Fprintln(os.Stdout, msg.Content)
+			}
+		}
+	}()
+	defer func() {
+		close(rec)
+		<-printed
+	}()
+
+	if err := runHooks(ctx, rec, e.ID(), "before_start", c.BeforeStart, e.prepare); err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := runHooks(context.Background(), rec, e.ID(), "after_stop", c.AfterStop, e.prepare); err != nil {
+			rec <- output.Message{ID: e.ID(), Type: output.Error, Content: err.Error()}
+		}
+	}()
+	if c.Limits.IsSet() {
+		var warnings []string
+		e.limiter, warnings = newLimiter(c.ID, c.Limits)
+		for _, warning := range warnings {
+			rec <- output.Message{ID: e.ID(), Type: output.Error, Content: warning}
+		}
+		defer func() {
+			if err := e.limiter.Close(); err != nil {
+				logger.Debugf("can't remove cgroup of %v: %v\n", e.ID(), err)
+			}
+		}()
+	}
+	if !e.build(ctx, rec) {
+		return 0, fmt.Errorf("can't build %v", c.ID)
+	}
+
+	cmd := exec.CommandContext(ctx, e.cmd, e.args...)
+	e.prepare(cmd)
+	if e.limiter != nil {
+		if err := e.limiter.Wrap(cmd); err != nil {
+			return 0, err
+		}
+	}
+	// The terminal of kommence is the one of the executable, unless there is none
+	var tty *os.File
+	attrs := &syscall.SysProcAttr{}
+	if c.TTY && !term.IsTerminal(int(os.Stdout.Fd())) {
+		attrs.Setsid, attrs.Setctty = true, true
+	}
+	dieWithKommence(attrs)
+	if e.limiter != nil {
+		e.limiter.Prepare(attrs)
+	}
+	release(e.reserved)
+	if attrs.Setctty {
+		tty, err = pty.StartWithAttrs(cmd, windowSize(), attrs)
+	} else {
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.SysProcAttr = attrs
+		err = cmd.Start()
+	}
+	if err != nil {
+		code, reason := notStarted(e.cmd, err)
+		rec <- output.Message{ID: e.ID(), Type: output.Error, Content: reason}
+		return code, nil
+	}
+	copied := make(chan struct{})
+	if tty != nil {
+		go func() {
+			_, _ = io.Copy(tty, os.Stdin)
+		}()
+		go func() {
+			defer close(copied)
+			_, _ = io.Copy(os.Stdout, tty)
+		}()
+	} else {
+		close(copied)
+	}
+
+	// The terminal sends Ctrl-C to the process as well: kommence waits for it to exit
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	handled := make(chan struct{})
+	go func() {
+		defer close(handled)
+		for sig := range signals {
+			if sig == syscall.SIGTERM {
+				if err := runHooks(context.Background(), rec, e.ID(), "before_stop", c.BeforeStop, e.prepare); err != nil {
+					rec <- output.Message{ID: e.ID(), Type: output.Error, Content: err.Error()}
+				}
+			}
+			// Without a terminal of its own, the process only gets signals from kommence
+			if sig == syscall.SIGTERM || tty != nil {
+				_ = cmd.Process.Signal(sig)
+			}
+		}
+	}()
+	defer func() {
+		signal.Stop(signals)
+		close(signals)
+		<-handled
+	}()
+	started := make(chan struct{})
+	go func() {
+		defer close(started)
+		if err := runHooks(ctx, rec, e.ID(), "after_start", c.AfterStart, e.prepare); err != nil {
+			rec <- output.Message{ID: e.ID(), Type: output.Error, Content: err.Error()}
+		}
+	}()
+
+	<-copied
+	err = cmd.Wait()
+	if tty != nil {
+		_ = tty.Close()
+	}
+	<-started
+	e.reportLimits(rec)
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		// Like shells, killed by a signal is 128+signal
+		if status, ok := exit.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal()), nil
+		}
+		return exit.ExitCode(), nil
+	}
+	return 0, err
+}
+
+// notStarted returns the exit code of shells for a command that can't run, 127 if it's not found and 126 otherwise, and why.
+func notStarted(name string, err error) (int, string) {
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) {
+		return 127, fmt.Sprintf("%v: command not found", name)
+	}
+	return 126, err.Error()
+}
@@ -0,0 +1,97 @@
+package runner_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AntoineToussaint/kommence/pkg/configuration"
+	"github.com/AntoineToussaint/kommence/pkg/output"
+	"github.com/AntoineToussaint/kommence/pkg/runner"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/term"
+)
+
+func TestForeground(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "test.sh"), []byte("test \"$MODE\" = ci || exit 2\nexit 3\n"), 0755))
+	log := output.NewLogger(false)
+
+	// Env and path are used
+	config := configuration.Executable{ID: "X", Cmd: "sh test.sh", Path: dir, Env: map[string]string{"MODE": "ci"}}
+	code, err := runner.Foreground(context.Background(), log, &configuration.Configuration{}, &config)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, code)
+
+	// Not started when the build fails
+	config.Build = "false"
+	_, err = runner.Foreground(context.Background(), log, &configuration.Configuration{}, &config)
+	assert.EqualError(t, err, "can't build X")
+
+	// Not found, like in shells
+	config = configuration.Executable{ID: "X", Cmd: "kommence-missing-command"}
+	code, err = runner.Foreground(context.Background(), log, &configuration.Configuration{}, &config)
+	assert.NoError(t, err)
+	assert.Equal(t, 127, code)
+}
+
+func TestForegroundHooks(t *testing.T) {
+	dir := t.TempDir()
+	config := configuration.Executable{ID: "X", Cmd: "true", Path: dir, Hooks: configuration.Hooks{
+		BeforeStart: []configuration.Hook{{Cmd: "touch before_start"}},
+		AfterStart:  []configuration.Hook{{Cmd: "touch after_start"}},
+		AfterStop:   []configuration.Hook{{Cmd: "touch after_stop"}},
+	}}
+	code, err := runner.Foreground(context.Background(), output.NewLogger(false), &configuration.Configuration{}, &config)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, code)
+	for _, stage := range []string{"before_start", "after_start", "after_stop"} {
+		assert.FileExists(t, filepath.Join(dir, stage))
+	}
+}
+
+func TestForegroundTTY(t *testing.T) {
+	if term.IsTerminal(int(os.Stdout.Fd())) {
+		t.Skip("the executable gets the terminal of the test")
+	}
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "tty.sh"), []byte("test -t 1 || exit 2\nexit 3\n"), 0755))
+	config := configuration.Executable{ID: "X", Cmd: "sh tty.sh", Path: dir, TTY: true}
+	code, err := runner.Foreground(context.Background(), output.NewLogger(false), &configuration.Configuration{}, &config)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, code)
+}
+
+func TestForegroundPorts(t *testing.T) {
+	l, err := net.Listen("tcp", ":0")
+	assert.NoError(t, err)
+	defer l.Close()
+	used := l.Addr().(*net.TCPAddr).Port
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "ports.sh"), []byte(fmt.Sprintf("test \"$DB\" = %d || exit 2\ntest \"$HTTP\" -gt 0 || exit 2\nexit 3\n", used)), 0755))
+
+	db := &configuration.Executable{ID: "db", Cmd: "sleep 5", Ports: configuration.Ports{"sql": {Number: used}}}
+	api := &configuration.Executable{ID: "api", Cmd: "sh ports.sh", Path: dir, Ports: configuration.Ports{"http": {Auto: true}}, Env: map[string]string{
+		"DB":   "${ports.db.sql}",
+		"HTTP": "${ports.api.http}",
+	}}
+	config := &configuration.Configuration{Execs: &configuration.Executables{
+		Commands:  map[string]*configuration.Executable{db.ID: db, api.ID: api},
+		Shortcuts: map[string]*configuration.Executable{},
+	}}
+	log := output.NewLogger(false)
+
+	// Its automatic ports and the fixed ports of other tasks are interpolated
+	code, err := runner.Foreground(context.Background(), log, config, api)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, code)
+
+	// Unknown ports and ports in use don't start it
+	_, err = runner.Foreground(context.Background(), log, config, &configuration.Executable{ID: "web", Cmd: "true", Env: map[string]string{"API": "${ports.api.debug}"}})
+	assert.EqualError(t, err, "not starting web: unknown port ${ports.api.debug}")
+	_, err = runner.Foreground(context.Background(), log, config, db)
+	assert.ErrorContains(t, err, fmt.Sprintf("not starting db: sql: port %d is used", used))
+}
@@ -0,0 +1,55 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/AntoineToussaint/kommence/pkg/configuration"
+	"github.com/AntoineToussaint/kommence/pkg/output"
+)
+
+// runHooks runs hooks in order and stops at the first failure. Their output is sent as logs of id.
+func runHooks(ctx context.Context, rec chan output.Message, id string, stage string, hooks []configuration.Hook, prepare func(*exec.Cmd)) error {
+	for _, hook := range hooks {
+		if err := runHook(ctx, rec, id, hook, prepare); err != nil {
+			return fmt.Errorf("%v hook %q failed: %v", stage, hook.Cmd, err)
+		}
+	}
+	return nil
+}
+
+func runHook(ctx context.Context, rec chan output.Message, id string, hook configuration.Hook, prepare func(*exec.Cmd)) error {
+	timeout := hook.GetTimeout()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	args := strings.Split(hook.Cmd, " ")
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	if prepare != nil {
+		prepare(cmd)
+	}
+	// Kill the whole group on timeout: children could keep the output open
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	dieWithKommence(cmd.SysProcAttr)
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = time.Second
+	logs := output.NewLineBreaker(rec, id, output.Log)
+	cmd.Stdout = logs
+	cmd.Stderr = logs
+	err := cmd.Start()
+	if err == nil {
+		recorder.Load().Add(id, cmd.Process.Pid)
+		err = cmd.Wait()
+		recorder.Load().Remove(cmd.Process.Pid)
+	}
+	_ = logs.Close()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %v", timeout)
+	}
+	return err
+}
@@ -0,0 +1,95 @@
+package runner
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// Cluster is the Kubernetes API used by pods.
+type Cluster interface {
+	Client() kubernetes.Interface
+	// PortForwardDialer dials the port forward endpoint of a pod
+	PortForwardDialer(pod v1.Pod) (httpstream.Dialer, error)
+	// Executor runs a command in a container of a pod
+	Executor(pod v1.Pod, options *v1.PodExecOptions) (remotecommand.Executor, error)
+}
+
+// Kube is a connection to a cluster.
+type Kube struct {
+	Clientset kubernetes.Interface
+	Config    *rest.Config
+}
+
+func (k *Kube) Client() kubernetes.Interface {
+	return k.Clientset
+}
+
+func (k *Kube) PortForwardDialer(pod v1.Pod) (httpstream.Dialer, error) {
+	transport, upgrader, err := spdy.RoundTripperFor(k.Config)
+	if err != nil {
+		return nil, err
+	}
+	return spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, PortForwardURL(k.Clientset, pod)), nil
+}
+
+func (k *Kube) Executor(pod v1.Pod, options *v1.PodExecOptions) (remotecommand.Executor, error) {
+	u := k.Clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(pod.Namespace).
+		Name(pod.Name).
+		SubResource("exec").
+		VersionedParams(options, scheme.ParameterCodec).
+		URL()
+	return remotecommand.NewSPDYExecutor(k.Config, http.MethodPost, u)
+}
+
+// KubeLoader loads the configuration like kubectl: the kubeconfig path if set, otherwise KUBECONFIG
+// or ~/.kube/config, otherwise in-cluster. Connections are loaded once per context.
+type KubeLoader struct {
+	path string
+	// context used when pods don't set one, the current context if empty
+	context string
+
+	mu    sync.Mutex
+	kubes map[string]*Kube
+}
+
+func NewKubeLoader(path string, context string) *KubeLoader {
+	return &KubeLoader{path: path, context: context, kubes: make(map[string]*Kube)}
+}
+
+// Load the connection to the cluster of a context, the default one if empty.
+func (l *KubeLoader) Load(context string) (*Kube, error) {
+	if context == "" {
+		context = l.context
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if kube, ok := l.kubes[context]; ok {
+		return kube, nil
+	}
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = l.path
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: context}
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("can't load kubernetes configuration: %v", err)
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("can't create kubernetes client: %v", err)
+	}
+	kube := &Kube{Clientset: client, Config: config}
+	l.kubes[context] = kube
+	return kube, nil
+}
@@ -0,0 +1,51 @@
+package runner_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AntoineToussaint/kommence/pkg/runner"
+	"github.com/stretchr/testify/assert"
+)
+
+const kubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: dev
+  cluster:
+    server: https://dev.example.com:6443
+- name: staging
+  cluster:
+    server: https://staging.example.com:6443
+users:
+- name: me
+  user:
+    token: secret
+contexts:
+- name: dev
+  context: {cluster: dev, user: me}
+- name: staging
+  context: {cluster: staging, user: me}
+current-context: dev
+`
+
+func TestKubeLoader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	assert.NoError(t, os.WriteFile(path, []byte(kubeconfig), 0600))
+
+	kube, err := runner.NewKubeLoader(path, "").Load("")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://dev.example.com:6443", kube.Config.Host)
+
+	loader := runner.NewKubeLoader(path, "staging")
+	kube, err = loader.Load("")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://staging.example.com:6443", kube.Config.Host)
+	kube, err = loader.Load("dev")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://dev.example.com:6443", kube.Config.Host)
+
+	_, err = loader.Load("prod")
+	assert.Error(t, err)
+}
@@ -0,0 +1,229 @@
+//go:build linux
+
+package runner
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/AntoineToussaint/kommence/pkg/configuration"
+)
+
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cpuPeriod is the cgroup CPU period in microseconds.
+const cpuPeriod = 100000
+
+// limiter applies Limits to the processes of an executable.
+//
+// Memory, CPU and processes are limited by a cgroup v2 sub-group when kommence is allowed to create one.
+// Otherwise, memory and processes fall back to rlimits, which are per process (memory) or per user (processes).
+// Open files are always limited by an rlimit. The rlimits are set before the executable runs.
+type limiter struct {
+	limits configuration.Limits
+	cgroup string
+	dir    *os.File
+
+	// Counters already reported
+	mu        sync.Mutex
+	ooms      uint64
+	throttled uint64
+}
+
+// newLimiter prepares the limits, the warnings are about the limits that can't be enforced.
+func newLimiter(name string, limits configuration.Limits) (*limiter, []string) {
+	l := &limiter{limits: limits}
+	var warnings []string
+	if limits.Memory == "" && limits.CPU == 0 && limits.Processes == 0 {
+		return l, nil
+	}
+	cgroup, err := createCgroup(name, limits)
+	if err != nil {
+		warnings = append(warnings, fmt.Sprintf("cgroup v2 not available, falling back to rlimits: %v", err))
+		if limits.CPU > 0 {
+			warnings = append(warnings, "cpu limit ignored: it requires cgroup v2")
+		}
+		return l, warnings
+	}
+	dir, err := os.Open(cgroup)
+	if err != nil {
+		_ = os.Remove(cgroup)
+		return l, append(warnings, fmt.Sprintf("can't open cgroup: %v", err))
+	}
+	l.cgroup, l.dir = cgroup, dir
+	return l, nil
+}
+
+// Prepare the command to start directly in the cgroup.
+func (l *limiter) Prepare(attr *syscall.SysProcAttr) {
+	if l.dir == nil {
+		return
+	}
+	attr.UseCgroupFD = true
+	attr.CgroupFD = int(l.dir.Fd())
+}
+
+// Wrap the command to set the rlimits in its process before it runs: its children inherit them.
+func (l *limiter) Wrap(cmd *exec.Cmd) error {
+	var limits []string
+	if n := l.limits.OpenFiles; n > 0 {
+		limits = append(limits, fmt.Sprintf("ulimit -n %d", n))
+	}
+	if l.cgroup == "" {
+		if mem := l.limits.GetMemory(); mem > 0 {
+			limits = append(limits, fmt.Sprintf("ulimit -v %d", mem/1024))
+		}
+		// dash calls the processes limit -p, other shells -u
+		if n := l.limits.Processes; n > 0 {
+			limits = append(limits, fmt.Sprintf("{ ulimit -u %d || ulimit -p %d; } 2>/dev/null", n, n))
+		}
+	}
+	// A command that can't be found fails to start as is
+	if len(limits) == 0 || cmd.Err != nil {
+		return nil
+	}
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		return fmt.Errorf("can't set limits: %v", err)
+	}
+	script := strings.Join(limits, " && ") + ` && exec "$@"`
+	cmd.Args = append([]string{"sh", "-c", script, "sh", cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = sh
+	return nil
+}
+
+// Events returns what happened in the cgroup since the last call: OOM kills and throttling.
+func (l *limiter) Events() []string {
+	if l.cgroup == "" {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var events []string
+	if ooms := readCounter(filepath.Join(l.cgroup, "memory.events"), "oom_kill"); ooms > l.ooms {
+		events = append(events, fmt.Sprintf("☠️ OOM killed: memory above %v", l.limits.Memory))
+		l.ooms = ooms
+	}
+	if throttled := readCounter(filepath.Join(l.cgroup, "cpu.stat"), "nr_throttled"); throttled > l.throttled {
+		// Only report the first throttling of a series
+		if l.throttled == 0 {
+			events = append(events, fmt.Sprintf("🐢 CPU throttled: above %v cores", l.limits.CPU))
+		}
+		l.throttled = throttled
+	}
+	return events
+}
+
+// Close removes the cgroup, once all processes are gone.
+func (l *limiter) Close() error {
+	if l.dir == nil {
+		return nil
+	}
+	_ = l.dir.Close()
+	return os.Remove(l.cgroup)
+}
+
+// createCgroup creates a sub-group of the cgroup kommence runs in.
+func createCgroup(name string, limits configuration.Limits) (string, error) {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return "", fmt.Errorf("no unified hierarchy")
+	}
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	parent := ""
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "0::") {
+			parent = filepath.Join(cgroupRoot, line[3:])
+		}
+	}
+	if parent == "" {
+		return "", fmt.Errorf("no unified hierarchy")
+	}
+	var controllers []string
+	if limits.Memory != "" {
+		controllers = append(controllers, "memory")
+	}
+	if limits.CPU > 0 {
+		controllers = append(controllers, "cpu")
+	}
+	if limits.Processes > 0 {
+		controllers = append(controllers, "pids")
+	}
+	for _, c := range controllers {
+		if err := enableController(parent, c); err != nil {
+			return "", err
+		}
+	}
+	name = strings.ReplaceAll(name, "/", "-")
+	cgroup := filepath.Join(parent, fmt.Sprintf("kommence-%d-%s", os.Getpid(), name))
+	if err := os.Mkdir(cgroup, 0755); err != nil && !os.IsExist(err) {
+		return "", err
+	}
+	write := func(file string, v string) error {
+		return os.WriteFile(filepath.Join(cgroup, file), []byte(v), 0644)
+	}
+	if mem := limits.GetMemory(); mem > 0 {
+		if err := write("memory.max", strconv.FormatUint(mem, 10)); err != nil {
+			_ = os.Remove(cgroup)
+			return "", err
+		}
+	}
+	if limits.CPU > 0 {
+		if err := write("cpu.max", fmt.Sprintf("%d %d", int(limits.CPU*cpuPeriod), cpuPeriod)); err != nil {
+			_ = os.Remove(cgroup)
+			return "", err
+		}
+	}
+	if limits.Processes > 0 {
+		if err := write("pids.max", strconv.FormatUint(limits.Processes, 10)); err != nil {
+			_ = os.Remove(cgroup)
+			return "", err
+		}
+	}
+	return cgroup, nil
+}
+
+func enableController(cgroup string, controller string) error {
+	data, err := os.ReadFile(filepath.Join(cgroup, "cgroup.subtree_control"))
+	if err != nil {
+		return err
+	}
+	for _, c := range strings.Fields(string(data)) {
+		if c == controller {
+			return nil
+		}
+	}
+	// Only possible when no process lives in the group itself, like the root of a container
+	err = os.WriteFile(filepath.Join(cgroup, "cgroup.subtree_control"), []byte("+"+controller), 0644)
+	if err != nil {
+		return fmt.Errorf("can't enable %v controller: %v", controller, err)
+	}
+	return nil
+}
+
+// readCounter reads a "key value" counter from a cgroup file like memory.events.
+func readCounter(file string, key string) uint64 {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	r := bufio.NewScanner(f)
+	for r.Scan() {
+		fields := strings.Fields(r.Text())
+		if len(fields) == 2 && fields[0] == key {
+			v, _ := strconv.ParseUint(fields[1], 10, 64)
+			return v
+		}
+	}
+	return 0
+}
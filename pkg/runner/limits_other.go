@@ -0,0 +1,31 @@
+//go:build !linux
+
+package runner
+
+import (
+	"os/exec"
+	"syscall"
+
+	"github.com/AntoineToussaint/kommence/pkg/configuration"
+)
+
+// limiter is only implemented on Linux.
+type limiter struct{}
+
+func newLimiter(name string, limits configuration.Limits) (*limiter, []string) {
+	return &limiter{}, []string{"resource limits are only supported on Linux"}
+}
+
+func (l *limiter) Prepare(attr *syscall.SysProcAttr) {}
+
+func (l *limiter) Wrap(cmd *exec.Cmd) error {
+	return nil
+}
+
+func (l *limiter) Events() []string {
+	return nil
+}
+
+func (l *limiter) Close() error {
+	return nil
+}
@@ -5,50 +5,53 @@ import (
 	"fmt"
 	"github.com/AntoineToussaint/kommence/pkg/configuration"
 	"github.com/AntoineToussaint/kommence/pkg/output"
-	"io"
+	"github.com/AntoineToussaint/kommence/pkg/ports"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	apiwatch "k8s.io/apimachinery/pkg/watch"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/tools/portforward"
-	"k8s.io/client-go/transport/spdy"
-	"net/http"
 	"net/url"
-	"os"
-	"os/exec"
 	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-var client *kubernetes.Clientset
-var config *rest.Config
+// Reconnection backoff of port forwards
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 30 * time.Second
+)
 
-func LoadKubeClient(p string) {
-	//home := homedir.HomeDir()
-	//kubeconfig := path.Join(home, ".kube/config")
-	var err error
-	config, err = clientcmd.BuildConfigFromFlags("", p)
-	if err != nil {
-		panic(err.Error())
-	}
-	// creates the client
-	client, err = kubernetes.NewForConfig(config)
-	if err != nil {
-		panic(err.Error())
-	}
-}
+// stopTimeout is how long Stop waits for the port forward to be closed.
+const stopTimeout = 5 * time.Second
 
 type Pod struct {
 	config *configuration.Pod
 	logger *output.Logger
+	kube   Cluster
+	// reserved local ports, released when forwarding
+	reserved []*ports.Reservation
+
+	// cancel the port forward, done when it is closed
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
 }
 
-func NewPod(logger *output.Logger, c *configuration.Pod) Runnable {
+func NewPod(logger *output.Logger, c *configuration.Pod, kube Cluster) Runnable {
+	return newPod(logger, c, kube)
+}
+
+func newPod(logger *output.Logger, c *configuration.Pod, kube Cluster) *Pod {
 	return &Pod{
 		logger: logger,
 		config: c,
+		kube:   kube,
 	}
 }
 
@@ -58,40 +61,244 @@ func (p *Pod) ID() string {
 
 func (p *Pod) Start(ctx context.Context, rec chan output.Message) error {
 	// We need to get one pod
-	p.logger.Debugf("looking for service %v in namespace %v\n", p.config.Service, p.config.Namespace)
-	pods, err := client.CoreV1().Pods(p.config.Namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: fmt.Sprintf("app=%s", p.config.Service)})
-	if err != nil {
-		panic(err.Error())
+	p.logger.Debugf("looking for %v in namespace %v\n", p.config.Target(), p.config.Namespace)
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	// The events tell why no pod is ready
+	if p.config.Events {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.followEvents(ctx, rec)
+		}()
 	}
-	if len(pods.Items) == 0 {
-		return fmt.Errorf("no pod found in namespace %v", p.config.Namespace)
+	selected, err := SelectPod(ctx, p.kube.Client(), p.config)
+	if err == nil {
+		p.logger.Debugf("selected pod %v\n", selected.Name)
+		// Configuration errors stop here, the ports are resolved again on reconnection
+		_, err = p.portSpecs(ctx, selected)
 	}
-	var pod v1.Pod = pods.Items[0]
+	// Without a ready pod yet, like during a rollout, it waits for one
+	if err != nil && selected != nil {
+		cancel()
+		wg.Wait()
+		return err
+	}
+	done := make(chan struct{})
+	p.mu.Lock()
+	p.cancel, p.done = cancel, done
+	p.mu.Unlock()
+	wg.Add(1)
 	go func() {
-		p.logger.Debugf("aggregating log for pod: %v\n", pod.Name)
-		err = p.aggregateLog(ctx, pod, rec)
-		if err != nil {
-			p.logger.Errorf("can't aggregate log: %v", err)
+		defer wg.Done()
+		if selected == nil {
+			rec <- output.Message{ID: p.ID(), Type: output.Error, Content: fmt.Sprintf("waiting for a ready pod: %v", err)}
+			pod, ok := p.waitPod(ctx)
+			if !ok {
+				return
+			}
+			p.connection(rec, "pod %v is ready", pod.Name)
+			selected = &pod
 		}
+		p.follow(ctx, *selected, rec)
 	}()
 	go func() {
-		err = p.forward(ctx, pod, rec)
-		if err != nil {
-			p.logger.Errorf("cannot forward: %v", err)
-		}
-
+		wg.Wait()
+		close(done)
 	}()
 	return nil
 }
 
+// follow the logs of the pod and forward to it until the context is done.
+func (p *Pod) follow(ctx context.Context, pod v1.Pod, rec chan output.Message) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		p.logger.Debugf("following logs of pod: %v\n", pod.Name)
+		p.followLogs(ctx, pod, rec)
+	}()
+	if len(p.config.Forwards()) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.supervise(ctx, pod, rec)
+		}()
+	}
+	wg.Wait()
+}
+
+// waitPod selects a ready pod with backoff until there is one, false if the context is done first.
+func (p *Pod) waitPod(ctx context.Context) (v1.Pod, bool) {
+	delay := minReconnectDelay
+	for {
+		p.wait(ctx, delay)
+		if ctx.Err() != nil {
+			return v1.Pod{}, false
+		}
+		delay = backoff(delay)
+		selected, err := SelectPod(ctx, p.kube.Client(), p.config)
+		if err == nil {
+			return *selected, true
+		}
+		p.logger.Debugf("%v: no ready pod: %v\n", p.ID(), err)
+	}
+}
+
+// supervise forwards to the pod until the context is done.
+// When the pod goes away or the connection is lost, a ready pod is selected again and the forward reconnects with backoff.
+func (p *Pod) supervise(ctx context.Context, pod v1.Pod, rec chan output.Message) {
+	delay := minReconnectDelay
+	for {
+		connected := time.Now()
+		err := p.forward(ctx, pod, rec)
+		if ctx.Err() != nil {
+			return
+		}
+		// A forward that held for a while starts over
+		if time.Since(connected) > maxReconnectDelay {
+			delay = minReconnectDelay
+		}
+		p.connection(rec, "lost connection to pod %v: %v", pod.Name, err)
+		for {
+			p.connection(rec, "reconnecting in %v", delay)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			delay = backoff(delay)
+			selected, err := SelectPod(ctx, p.kube.Client(), p.config)
+			if err == nil {
+				pod = *selected
+				break
+			}
+			p.connection(rec, "%v", err)
+		}
+		p.connection(rec, "forwarding to pod %v", pod.Name)
+	}
+}
+
+// connection reports an event of the port forward.
+func (p *Pod) connection(rec chan output.Message, format string, args ...interface{}) {
+	rec <- output.Message{ID: p.ID(), Type: output.PodConnection, Content: fmt.Sprintf(format, args...)}
+}
+
+// Stop following logs and forwarding, and wait for the port forward to be closed.
+// The context is usually done already: stopping only gets its timeout.
 func (p *Pod) Stop(ctx context.Context, rec chan output.Message) error {
 	p.logger.Debugf("stopping forwarding pod: %v\n", p.ID())
-	return nil
+	rec <- output.Message{ID: p.ID(), Type: output.Stop, Content: "Stopping"}
+	p.mu.Lock()
+	cancel, done := p.cancel, p.done
+	p.mu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(stopTimeout):
+		return fmt.Errorf("%v still stopping after %v", p.ID(), stopTimeout)
+	}
+}
+
+// watchPod cancels the port forward when the pod is deleted or is not ready anymore.
+func (p *Pod) watchPod(ctx context.Context, pod v1.Pod, rec chan output.Message, gone func(reason string)) {
+	selector := fields.OneTermEqualSelector("metadata.name", pod.Name).String()
+	pods := p.kube.Client().CoreV1().Pods(pod.Namespace)
+	version := pod.ResourceVersion
+	delay := minReconnectDelay
+	for ctx.Err() == nil {
+		if version == "" {
+			// Watching from now only reports changes: check the pod as it is
+			list, err := pods.List(ctx, metav1.ListOptions{FieldSelector: selector})
+			if err == nil {
+				version = list.ResourceVersion
+				if reason := podGone(list.Items, pod.Name); reason != "" {
+					gone(reason)
+					return
+				}
+			} else if ctx.Err() == nil {
+				p.connection(rec, "can't get pod %v: %v", pod.Name, err)
+				p.wait(ctx, delay)
+				delay = backoff(delay)
+				continue
+			}
+		}
+		w, err := pods.Watch(ctx, metav1.ListOptions{FieldSelector: selector, ResourceVersion: version})
+		if err != nil {
+			if ctx.Err() == nil {
+				p.connection(rec, "can't watch pod %v: %v", pod.Name, err)
+				version = ""
+				p.wait(ctx, delay)
+				delay = backoff(delay)
+			}
+			continue
+		}
+		delay = minReconnectDelay
+		stop := stopOnDone(ctx, w)
+		for event := range w.ResultChan() {
+			switch event.Type {
+			case apiwatch.Deleted:
+				stop()
+				gone("pod deleted")
+				return
+			case apiwatch.Added, apiwatch.Modified:
+				if updated, ok := event.Object.(*v1.Pod); ok && updated.Name == pod.Name {
+					version = updated.ResourceVersion
+					if !isReady(*updated) {
+						stop()
+						gone("pod not ready")
+						return
+					}
+				}
+			case apiwatch.Error:
+				// Watch again from the last known version, or from now if it's too old
+				version = ""
+			}
+		}
+		// The server closes watches after a while
+		stop()
+	}
+}
+
+// podGone returns why the pod isn't there to forward to anymore, if it isn't.
+func podGone(pods []v1.Pod, name string) string {
+	for _, pod := range pods {
+		if pod.Name != name {
+			continue
+		}
+		if !isReady(pod) {
+			return "pod not ready"
+		}
+		return ""
+	}
+	return "pod deleted"
+}
+
+// backoff returns the next reconnection delay.
+func backoff(delay time.Duration) time.Duration {
+	delay *= 2
+	if delay > maxReconnectDelay {
+		delay = maxReconnectDelay
+	}
+	return delay
+}
+
+// PortForwardURL returns the port forward URL of a pod, on the server of the client with its scheme and path prefix.
+func PortForwardURL(client kubernetes.Interface, pod v1.Pod) *url.URL {
+	return client.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(pod.Namespace).
+		Name(pod.Name).
+		SubResource("portforward").
+		URL()
 }
 
 func (p *Pod) forward(ctx context.Context, pod v1.Pod, rec chan output.Message) error {
 	stream := genericclioptions.IOStreams{
-		In:     os.Stdin,
 		Out:    output.NewLineBreaker(rec, p.ID(), output.PodConnection),
 		ErrOut: output.NewLineBreaker(rec, p.ID(), output.PodConnection),
 	}
@@ -102,60 +309,89 @@ func (p *Pod) forward(ctx context.Context, pod v1.Pod, rec chan output.Message)
 	// ready communicate when the port forward is ready to get traffic
 	ready := make(chan struct{})
 
-	p.logger.Debugf("running port forward for pod %v %v:%v", pod.Name, p.config.LocalPort, p.config.PodPort)
-
-	req := PortForwardAPodRequest{
-		Pod:       pod,
-		LocalPort: p.config.LocalPort,
-		PodPort:   p.config.PodPort,
-		Streams:   stream,
-		Stop:      stop,
-		Ready:     ready,
-	}
-	transport, upgrader, err := spdy.RoundTripperFor(config)
+	ports, err := p.portSpecs(ctx, &pod)
 	if err != nil {
 		return err
 	}
 
-	forwardPath := fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/portforward", req.Pod.Namespace, req.Pod.Name)
-	hostIP := strings.TrimLeft(config.Host, "https:/")
-
-	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, &url.URL{Scheme: "https", Path: forwardPath, Host: hostIP})
+	// Stop when stopped or when the pod goes away
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var reason atomic.Pointer[string]
+	go p.watchPod(ctx, pod, rec, func(r string) {
+		reason.Store(&r)
+		cancel()
+	})
+	go func() {
+		<-ctx.Done()
+		close(stop)
+	}()
+	p.logger.Debugf("running port forward for pod %v %v", pod.Name, ports)
 
-	fw, err := portforward.New(dialer, []string{fmt.Sprintf("%d:%d", req.LocalPort, req.PodPort)}, req.Stop, req.Ready, req.Streams.Out, req.Streams.ErrOut)
+	req := PortForwardAPodRequest{
+		Pod:     pod,
+		Ports:   ports,
+		Streams: stream,
+		Stop:    stop,
+		Ready:   ready,
+	}
+	dialer, err := p.kube.PortForwardDialer(req.Pod)
 	if err != nil {
 		return err
 	}
-	return fw.ForwardPorts()
-}
 
-func (p *Pod) aggregateLog(ctx context.Context, pod v1.Pod, rec chan output.Message) error {
-	// Hack it for now
-	// Log
-	args := []string{"kubectl", "logs", pod.Name, "-n", p.config.Namespace, "-f"}
-	// If a container is specified
-	if container := p.config.Container; container != "" {
-		args = append(args, container)
-	}
-	cmd := exec.Command(args[0], args[1:]...)
-	stdout, _ := cmd.StdoutPipe()
-	stderr, _ := cmd.StderrPipe()
-	err := cmd.Start()
+	fw, err := portforward.New(dialer, req.Ports, req.Stop, req.Ready, req.Streams.Out, req.Streams.ErrOut)
 	if err != nil {
-		p.logger.Errorf("%v: cmd.Start() failed with '%s'\n", err)
+		return err
 	}
-
 	go func() {
-		_, _ = io.Copy(output.NewLineBreaker(rec, p.ID(), output.Log), stdout)
+		select {
+		case <-ready:
+			p.reportPorts(rec, fw)
+		case <-ctx.Done():
+		}
 	}()
-	_, _ = io.Copy(output.NewLineBreaker(rec, p.ID(), output.Error), stderr)
-	return nil
+	// The forward binds the reserved ports
+	release(p.reserved)
+	err = fw.ForwardPorts()
+	if r := reason.Load(); r != nil {
+		return fmt.Errorf("%v", *r)
+	}
+	return err
+}
 
+// portSpecs resolves the forwarded ports of the pod as local:pod port numbers.
+func (p *Pod) portSpecs(ctx context.Context, pod *v1.Pod) ([]string, error) {
+	var specs []string
+	for _, f := range p.config.Forwards() {
+		port, err := ResolvePort(ctx, p.kube.Client(), p.config, pod, f.Remote)
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, fmt.Sprintf("%d:%d", f.Local.Number, port))
+	}
+	return specs, nil
+}
+
+// reportPorts reports the local ports actually bound, once the forward is ready.
+func (p *Pod) reportPorts(rec chan output.Message, fw *portforward.PortForwarder) {
+	forwarded, err := fw.GetPorts()
+	if err != nil {
+		return
+	}
+	forwards := p.config.Forwards()
+	var ports []string
+	for i, port := range forwarded {
+		if i < len(forwards) {
+			ports = append(ports, fmt.Sprintf("%v localhost:%v -> %v", forwards[i].Name, port.Local, port.Remote))
+		}
+	}
+	p.connection(rec, "forwarding %v", strings.Join(ports, ", "))
 }
 
-// MatchPod based on name
+// MatchPod based on name: the pods of a deployment (name-<hash>-<id>) or of a statefulset (name-<ordinal>)
 func MatchPod(name string, pod string) bool {
-	r, err := regexp.Compile(fmt.Sprintf(`%v-\w{7,10}-\w{5,7}`, name))
+	r, err := regexp.Compile(fmt.Sprintf(`^%v-(\w{7,10}-\w{5,7}|\d+)$`, regexp.QuoteMeta(name)))
 	if err != nil {
 		return false
 	}
@@ -165,10 +401,8 @@ func MatchPod(name string, pod string) bool {
 type PortForwardAPodRequest struct {
 	// Pod is the selected pod for this port forwarding
 	Pod v1.Pod
-	// LocalPort is the local port that will be selected to expose the PodPort
-	LocalPort int
-	// PodPort is the target port for the pod
-	PodPort int
+	// Ports are local:pod port numbers, a local port 0 being any free port
+	Ports []string
 	// Steams configures where to write or read input from
 	Streams genericclioptions.IOStreams
 	// Stop is the channel used to manage the port forward lifecycle
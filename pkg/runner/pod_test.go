@@ -1,13 +1,656 @@
 package runner_test
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AntoineToussaint/kommence/pkg/configuration"
+	"github.com/AntoineToussaint/kommence/pkg/output"
 	"github.com/AntoineToussaint/kommence/pkg/runner"
 	"github.com/stretchr/testify/assert"
-	"testing"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	spdystream "k8s.io/apimachinery/pkg/util/httpstream/spdy"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	apiwatch "k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/transport/spdy"
 )
 
 func TestMatch(t *testing.T) {
 	assert.True(t, runner.MatchPod("test", "test-9468448-j95hv"))
 	assert.False(t, runner.MatchPod("test", "tester-9468448-j95hv"))
 	assert.False(t, runner.MatchPod("test", "test-94684-j95hv"))
+	assert.True(t, runner.MatchPod("test", "test-0"))
+	assert.False(t, runner.MatchPod("test", "my-test-0"))
+}
+
+func pod(name string, age time.Duration, phase v1.PodPhase, ready bool) v1.Pod {
+	status := v1.ConditionFalse
+	if ready {
+		status = v1.ConditionTrue
+	}
+	return v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(time.Unix(1700000000, 0).Add(-age))},
+		Status: v1.PodStatus{
+			Phase:      phase,
+			Conditions: []v1.PodCondition{{Type: v1.PodReady, Status: status}},
+		},
+	}
+}
+
+func TestChoosePod(t *testing.T) {
+	terminating := pod("api-terminating", time.Hour, v1.PodRunning, true)
+	now := metav1.Now()
+	terminating.DeletionTimestamp = &now
+	pods := []v1.Pod{
+		pod("api-pending", time.Hour, v1.PodPending, false),
+		pod("api-unready", time.Hour, v1.PodRunning, false),
+		terminating,
+		pod("api-young", time.Minute, v1.PodRunning, true),
+		pod("api-b", 10*time.Minute, v1.PodRunning, true),
+		pod("api-a", 10*time.Minute, v1.PodRunning, true),
+	}
+	chosen, ok := runner.ChoosePod(pods)
+	assert.True(t, ok)
+	assert.Equal(t, "api-a", chosen.Name)
+
+	_, ok = runner.ChoosePod(pods[:3])
+	assert.False(t, ok)
+}
+
+func TestResolveServicePort(t *testing.T) {
+	api := pod("api-1", time.Minute, v1.PodRunning, true)
+	api.Namespace = "dev"
+	api.Labels = map[string]string{"app": "api-server"}
+	api.Spec.Containers = []v1.Container{{Name: "api", Ports: []v1.ContainerPort{{Name: "web", ContainerPort: 8080}}}}
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "dev"},
+		Spec: v1.ServiceSpec{
+			Selector: map[string]string{"app": "api-server"},
+			Ports: []v1.ServicePort{
+				{Name: "http", Port: 80, TargetPort: intstr.FromString("web")},
+				{Name: "grpc", Port: 9090, TargetPort: intstr.FromInt(9000)},
+			},
+		},
+	}
+	client := fake.NewSimpleClientset(&api, service)
+	ctx := context.Background()
+
+	c := &configuration.Pod{ID: "api", Namespace: "dev", Service: "api"}
+	selected, err := runner.SelectPod(ctx, client, c)
+	assert.NoError(t, err)
+	assert.Equal(t, "api-1", selected.Name)
+	for port, expected := range map[string]int{"http": 8080, "80": 8080, "grpc": 9000} {
+		resolved, err := runner.ResolvePort(ctx, client, c, selected, port)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, resolved)
+	}
+	for _, port := range []string{"", "metrics"} {
+		_, err = runner.ResolvePort(ctx, client, c, selected, port)
+		assert.Error(t, err)
+	}
+
+	// Named ports of the pod
+	c = &configuration.Pod{ID: "api", Namespace: "dev", Pod: "api-1"}
+	resolved, err := runner.ResolvePort(ctx, client, c, selected, "web")
+	assert.NoError(t, err)
+	assert.Equal(t, 8080, resolved)
+}
+
+func TestPortForwardURL(t *testing.T) {
+	pod := v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "api-0", Namespace: "dev"}}
+	for host, expected := range map[string]string{
+		"https://the-cluster:6443":                 "https://the-cluster:6443/api/v1/namespaces/dev/pods/api-0/portforward",
+		"http://127.0.0.1:8080":                    "http://127.0.0.1:8080/api/v1/namespaces/dev/pods/api-0/portforward",
+		"https://rancher.example/k8s/clusters/c-1": "https://rancher.example/k8s/clusters/c-1/api/v1/namespaces/dev/pods/api-0/portforward",
+		"https://shared.example":                   "https://shared.example/api/v1/namespaces/dev/pods/api-0/portforward",
+	} {
+		client, err := kubernetes.NewForConfig(&rest.Config{Host: host})
+		assert.NoError(t, err)
+		assert.Equal(t, expected, runner.PortForwardURL(client, pod).String(), host)
+	}
+}
+
+func TestSelectPod(t *testing.T) {
+	labeled := func(p v1.Pod, labels map[string]string) *v1.Pod {
+		p.Namespace = "dev"
+		p.Labels = labels
+		return &p
+	}
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "api"}}
+	client := fake.NewSimpleClientset(
+		labeled(pod("api-7d9f8b6c4-old00", time.Hour, v1.PodRunning, false), map[string]string{"app": "api"}),
+		labeled(pod("api-7d9f8b6c4-new00", time.Minute, v1.PodRunning, true), map[string]string{"app": "api"}),
+		labeled(pod("db-0", time.Hour, v1.PodRunning, true), map[string]string{"app": "db"}),
+		labeled(pod("db-1", time.Minute, v1.PodRunning, true), map[string]string{"app": "db"}),
+		labeled(pod("worker-0", time.Hour, v1.PodPending, false), map[string]string{"app": "worker"}),
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "dev"}, Spec: appsv1.DeploymentSpec{Selector: selector}},
+		&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "dev"}, Spec: appsv1.StatefulSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+		}},
+	)
+	ctx := context.Background()
+	for _, tc := range []struct {
+		config   configuration.Pod
+		expected string
+	}{
+		{configuration.Pod{Name: "api"}, "api-7d9f8b6c4-new00"},
+		{configuration.Pod{Name: "db"}, "db-0"},
+		{configuration.Pod{Deployment: "api"}, "api-7d9f8b6c4-new00"},
+		{configuration.Pod{StatefulSet: "db"}, "db-0"},
+		{configuration.Pod{Selector: "app=db"}, "db-0"},
+		{configuration.Pod{Pod: "db-1"}, "db-1"},
+	} {
+		tc.config.Namespace = "dev"
+		selected, err := runner.SelectPod(ctx, client, &tc.config)
+		assert.NoError(t, err, tc.config.Target())
+		if err == nil {
+			assert.Equal(t, tc.expected, selected.Name, tc.config.Target())
+		}
+	}
+	for _, c := range []configuration.Pod{
+		{Name: "worker"},
+		{Pod: "api-7d9f8b6c4-old00"},
+		{Pod: "missing"},
+		{Deployment: "missing"},
+		{Selector: "app=api", Namespace: "prod"},
+	} {
+		if c.Namespace == "" {
+			c.Namespace = "dev"
+		}
+		_, err := runner.SelectPod(ctx, client, &c)
+		assert.Error(t, err, c.Target())
+	}
+}
+
+// cluster is a fake clientset with port forwards to an echo server, recording execs.
+type cluster struct {
+	client *fake.Clientset
+	server *httptest.Server
+
+	exec    *v1.PodExecOptions
+	execPod string
+}
+
+func (c *cluster) Client() kubernetes.Interface {
+	return c.client
+}
+
+func (c *cluster) PortForwardDialer(pod v1.Pod) (httpstream.Dialer, error) {
+	transport, upgrader, err := spdy.RoundTripperFor(&rest.Config{Host: c.server.URL})
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(c.server.URL + "/" + pod.Name)
+	if err != nil {
+		return nil, err
+	}
+	return spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, u), nil
+}
+
+func (c *cluster) Executor(pod v1.Pod, options *v1.PodExecOptions) (remotecommand.Executor, error) {
+	c.exec, c.execPod = options, pod.Name
+	return executor{}, nil
+}
+
+// executor prints the command it runs.
+type executor struct{}
+
+func (executor) Stream(options remotecommand.StreamOptions) error {
+	return executor{}.StreamWithContext(context.Background(), options)
+}
+
+func (executor) StreamWithContext(_ context.Context, options remotecommand.StreamOptions) error {
+	_, err := options.Stdout.Write([]byte("executed"))
+	return err
+}
+
+// echoPortForwards serves port forwards, echoing what is sent to any port.
+func echoPortForwards() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if _, err := httpstream.Handshake(req, w, []string{portforward.PortForwardProtocolV1Name}); err != nil {
+			return
+		}
+		streams := make(chan httpstream.Stream, 10)
+		conn := spdystream.NewResponseUpgrader().UpgradeResponse(w, req, func(s httpstream.Stream, _ <-chan struct{}) error {
+			streams <- s
+			return nil
+		})
+		if conn == nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			select {
+			case s := <-streams:
+				if s.Headers().Get(v1.StreamType) == v1.StreamTypeError {
+					// No error to report
+					_ = s.Close()
+					continue
+				}
+				go func() {
+					_, _ = io.Copy(s, s)
+					_ = s.Close()
+				}()
+			case <-conn.CloseChan():
+				return
+			}
+		}
+	}))
+}
+
+// forwarded waits for the forward to be ready and returns its local port.
+func forwarded(t *testing.T, rec chan output.Message, events *[]string) int {
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case m := <-rec:
+			*events = append(*events, m.Content)
+			var port int
+			if _, err := fmt.Sscanf(m.Content, "forwarding http localhost:%d -> 80", &port); err == nil {
+				return port
+			}
+		case <-timeout:
+			t.Fatalf("forward not ready: %v", *events)
+		}
+	}
+}
+
+func echo(t *testing.T, port int) {
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte("ping"))
+	assert.NoError(t, err)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reply := make([]byte, 4)
+	_, err = io.ReadFull(conn, reply)
+	assert.NoError(t, err)
+	assert.Equal(t, "ping", string(reply))
+}
+
+func TestPodForward(t *testing.T) {
+	server := echoPortForwards()
+	defer server.Close()
+	first := pod("api-7d9f8b6c4-abcde", time.Hour, v1.PodRunning, true)
+	first.Namespace, first.UID = "dev", "1"
+	client := fake.NewSimpleClientset(&first)
+	c := &configuration.Pod{ID: "api", Name: "api", Namespace: "dev", Ports: []configuration.PortForward{
+		{Local: configuration.Port{Auto: true}, Remote: "80", Name: "http"},
+	}}
+	p := runner.NewPod(output.NewLogger(false), c, &cluster{client: client, server: server})
+	rec := make(chan output.Message, 1000)
+	ctx := context.Background()
+	assert.NoError(t, p.Start(ctx, rec))
+
+	var events []string
+	echo(t, forwarded(t, rec, &events))
+
+	// The pod is replaced: the forward moves to the new one
+	second := pod("api-7d9f8b6c4-fghij", 0, v1.PodRunning, true)
+	second.Namespace, second.UID = "dev", "2"
+	_, err := client.CoreV1().Pods("dev").Create(ctx, &second, metav1.CreateOptions{})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		for _, action := range client.Actions() {
+			if action.GetVerb() == "watch" {
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.NoError(t, client.CoreV1().Pods("dev").Delete(ctx, first.Name, metav1.DeleteOptions{}))
+	port := forwarded(t, rec, &events)
+	echo(t, port)
+	assert.Contains(t, events, "lost connection to pod api-7d9f8b6c4-abcde: pod deleted")
+	assert.Contains(t, events, "forwarding to pod api-7d9f8b6c4-fghij")
+
+	// Stopping waits for the forward to be closed even when the context is done already
+	stop, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.NoError(t, p.Stop(stop, rec))
+	_, err = net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	assert.Error(t, err)
+}
+
+func TestPodForwardWithoutReadyPod(t *testing.T) {
+	server := echoPortForwards()
+	defer server.Close()
+	client := fake.NewSimpleClientset()
+	c := &configuration.Pod{ID: "api", Name: "api", Namespace: "dev", Ports: []configuration.PortForward{
+		{Local: configuration.Port{Auto: true}, Remote: "80", Name: "http"},
+	}}
+	p := runner.NewPod(output.NewLogger(false), c, &cluster{client: client, server: server})
+	rec := make(chan output.Message, 1000)
+	ctx := context.Background()
+
+	// No pod yet, like during a rollout: it waits for one
+	assert.NoError(t, p.Start(ctx, rec))
+	waiting := <-rec
+	assert.Equal(t, output.Error, waiting.Type)
+	assert.Contains(t, waiting.Content, "waiting for a ready pod")
+
+	ready := pod("api-7d9f8b6c4-abcde", 0, v1.PodRunning, true)
+	ready.Namespace = "dev"
+	_, err := client.CoreV1().Pods("dev").Create(ctx, &ready, metav1.CreateOptions{})
+	assert.NoError(t, err)
+	var events []string
+	echo(t, forwarded(t, rec, &events))
+	assert.Contains(t, events, "pod api-7d9f8b6c4-abcde is ready")
+
+	stop, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	assert.NoError(t, p.Stop(stop, rec))
+}
+
+func TestPodWatchErrors(t *testing.T) {
+	server := echoPortForwards()
+	defer server.Close()
+	api := pod("api-7d9f8b6c4-abcde", time.Hour, v1.PodRunning, true)
+	api.Namespace = "dev"
+	client := fake.NewSimpleClientset(&api)
+	// The first watch fails, the second one expires
+	expiring := apiwatch.NewFake()
+	var watched int32
+	client.PrependWatchReactor("pods", func(k8stesting.Action) (bool, apiwatch.Interface, error) {
+		switch atomic.AddInt32(&watched, 1) {
+		case 1:
+			return true, nil, fmt.Errorf("connection refused")
+		case 2:
+			return true, expiring, nil
+		}
+		return false, nil, nil
+	})
+	c := &configuration.Pod{ID: "api", Name: "api", Namespace: "dev", Ports: []configuration.PortForward{
+		{Local: configuration.Port{Auto: true}, Remote: "80", Name: "http"},
+	}}
+	p := runner.NewPod(output.NewLogger(false), c, &cluster{client: client, server: server})
+	rec := make(chan output.Message, 1000)
+	ctx := context.Background()
+	assert.NoError(t, p.Start(ctx, rec))
+
+	var events []string
+	echo(t, forwarded(t, rec, &events))
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&watched) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The pod isn't ready anymore by the time the watch is restarted from now
+	api.Status.Conditions = []v1.PodCondition{{Type: v1.PodReady, Status: v1.ConditionFalse}}
+	_, err := client.CoreV1().Pods("dev").UpdateStatus(ctx, &api, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+	expiring.Error(&metav1.Status{Reason: metav1.StatusReasonExpired})
+	expiring.Stop()
+	timeout := time.After(5 * time.Second)
+	for !contains(events, "lost connection to pod api-7d9f8b6c4-abcde: pod not ready") {
+		select {
+		case m := <-rec:
+			events = append(events, m.Content)
+		case <-timeout:
+			t.Fatalf("connection not lost: %v", events)
+		}
+	}
+	assert.Contains(t, events, "can't watch pod api-7d9f8b6c4-abcde: connection refused")
+
+	stop, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.NoError(t, p.Stop(stop, rec))
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPodLogs(t *testing.T) {
+	api := pod("api-7d9f8b6c4-abcde", time.Hour, v1.PodRunning, true)
+	api.Namespace = "dev"
+	api.Spec.InitContainers = []v1.Container{{Name: "migrate"}}
+	api.Spec.Containers = []v1.Container{{Name: "api"}}
+	client := fake.NewSimpleClientset(&api)
+	c := &configuration.Pod{ID: "api", Name: "api", Namespace: "dev", Logs: configuration.PodLogs{
+		Containers: []string{"api", "sidecar", "migrate"},
+	}}
+	p := runner.NewPod(output.NewLogger(false), c, &cluster{client: client})
+	rec := make(chan output.Message, 1000)
+	ctx := context.Background()
+	assert.NoError(t, p.Start(ctx, rec))
+
+	expected := []output.Message{
+		{ID: p.ID(), Type: output.Error, Content: "pod api-7d9f8b6c4-abcde has no container sidecar"},
+		{ID: p.ID(), Type: output.Error, Content: "migrate is an init container of pod api-7d9f8b6c4-abcde: its logs need initContainers"},
+		{ID: p.ID(), Type: output.Log, Content: "fake logs"},
+	}
+	var messages []output.Message
+	timeout := time.After(5 * time.Second)
+	for len(messages) < len(expected) {
+		select {
+		case m := <-rec:
+			if m.Type == output.Error || m.Type == output.Log {
+				messages = append(messages, m)
+			}
+		case <-timeout:
+			t.Fatalf("missing messages: %v", messages)
+		}
+	}
+	assert.ElementsMatch(t, expected, messages)
+
+	stop, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.NoError(t, p.Stop(stop, rec))
+}
+
+func TestPodEvents(t *testing.T) {
+	controller := true
+	api := pod("api-7d9f8b6c4-abcde", time.Hour, v1.PodRunning, true)
+	api.Namespace, api.UID = "dev", "1"
+	api.OwnerReferences = []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "api-7d9f8b6c4", Controller: &controller}}
+	api.Spec.Containers = []v1.Container{{Name: "api"}}
+	api.Status.ContainerStatuses = []v1.ContainerStatus{{Name: "api"}}
+	rs := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+		Name: "api-7d9f8b6c4", Namespace: "dev",
+		OwnerReferences: []metav1.OwnerReference{{Kind: "Deployment", Name: "api", Controller: &controller}},
+	}}
+	client := fake.NewSimpleClientset(&api, rs)
+	c := &configuration.Pod{ID: "api", Name: "api", Namespace: "dev", Events: true}
+	p := runner.NewPod(output.NewLogger(false), c, &cluster{client: client})
+	rec := make(chan output.Message, 1000)
+	ctx := context.Background()
+	assert.NoError(t, p.Start(ctx, rec))
+	// The pod, its replicaset and its deployment
+	assert.Eventually(t, func() bool {
+		return watches(client, "events") == 3 && watches(client, "pods") > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	event := createEvent(t, client)
+	event("1", "Pod", "api-7d9f8b6c4-abcde", "BackOff", v1.EventTypeWarning, "Back-off restarting failed container")
+	event("2", "Pod", "web-5c8d7f9b6-xyzab", "BackOff", v1.EventTypeWarning, "not the forwarded pod")
+	event("3", "Deployment", "api", "ScalingReplicaSet", v1.EventTypeNormal, "Scaled up replica set api-7d9f8b6c4 to 2")
+	api.Status.ContainerStatuses = []v1.ContainerStatus{{
+		Name:                 "api",
+		RestartCount:         1,
+		LastTerminationState: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137}},
+	}}
+	_, err := client.CoreV1().Pods("dev").UpdateStatus(ctx, &api, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+
+	expected := []output.Message{
+		{ID: p.ID(), Type: output.PodWarning, Content: "BackOff pod/api-7d9f8b6c4-abcde: Back-off restarting failed container"},
+		{ID: p.ID(), Type: output.PodEvent, Content: "ScalingReplicaSet deployment/api: Scaled up replica set api-7d9f8b6c4 to 2"},
+		{ID: p.ID(), Type: output.PodWarning, Content: "OOMKilled container api: exit code 137, restarted 1 times"},
+	}
+	var events []output.Message
+	timeout := time.After(5 * time.Second)
+	for len(events) < len(expected) {
+		select {
+		case m := <-rec:
+			if m.Type == output.PodEvent || m.Type == output.PodWarning {
+				events = append(events, m)
+			}
+		case <-timeout:
+			t.Fatalf("missing events: %v", events)
+		}
+	}
+	assert.ElementsMatch(t, expected, events)
+
+	stop, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	assert.NoError(t, p.Stop(stop, rec))
+}
+
+func TestPodEventsWithoutReadyPod(t *testing.T) {
+	crashing := pod("api-7d9f8b6c4-abcde", time.Hour, v1.PodRunning, false)
+	pending := pod("api-7d9f8b6c4-fghij", time.Minute, v1.PodPending, false)
+	crashing.Namespace, pending.Namespace = "dev", "dev"
+	client := fake.NewSimpleClientset(&crashing, &pending)
+	c := &configuration.Pod{ID: "api", Name: "api", Namespace: "dev", Events: true}
+	p := runner.NewPod(output.NewLogger(false), c, &cluster{client: client})
+	rec := make(chan output.Message, 1000)
+	ctx := context.Background()
+	assert.NoError(t, p.Start(ctx, rec))
+	assert.Eventually(t, func() bool {
+		return watches(client, "events") == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	event := createEvent(t, client)
+	event("1", "Pod", "api-7d9f8b6c4-abcde", "BackOff", v1.EventTypeWarning, "Back-off restarting failed container")
+	event("2", "Pod", "api-7d9f8b6c4-fghij", "FailedScheduling", v1.EventTypeWarning, "0/3 nodes are available: 3 Insufficient memory.")
+
+	expected := []output.Message{
+		{ID: p.ID(), Type: output.PodWarning, Content: "BackOff pod/api-7d9f8b6c4-abcde: Back-off restarting failed container"},
+		{ID: p.ID(), Type: output.PodWarning, Content: "FailedScheduling pod/api-7d9f8b6c4-fghij: 0/3 nodes are available: 3 Insufficient memory."},
+	}
+	var events []output.Message
+	waiting := false
+	timeout := time.After(5 * time.Second)
+	for len(events) < len(expected) {
+		select {
+		case m := <-rec:
+			switch m.Type {
+			case output.Error:
+				waiting = waiting || strings.HasPrefix(m.Content, "waiting for a ready pod")
+			case output.PodWarning:
+				events = append(events, m)
+			}
+		case <-timeout:
+			t.Fatalf("missing events: %v", events)
+		}
+	}
+	assert.True(t, waiting)
+	assert.ElementsMatch(t, expected, events)
+
+	stop, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	assert.NoError(t, p.Stop(stop, rec))
+}
+
+// watches returns the number of watches of a resource.
+func watches(client *fake.Clientset, resource string) int {
+	n := 0
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "watch" && action.GetResource().Resource == resource {
+			n++
+		}
+	}
+	return n
+}
+
+// createEvent returns a function creating events in the dev namespace.
+func createEvent(t *testing.T, client *fake.Clientset) func(name string, kind string, object string, reason string, eventType string, message string) {
+	return func(name string, kind string, object string, reason string, eventType string, message string) {
+		_, err := client.CoreV1().Events("dev").Create(context.Background(), &v1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: name, Namespace: "dev"},
+			InvolvedObject: v1.ObjectReference{Kind: kind, Name: object, Namespace: "dev"},
+			Reason:         reason,
+			Type:           eventType,
+			Message:        message,
+		}, metav1.CreateOptions{})
+		assert.NoError(t, err)
+	}
+}
+
+func TestExec(t *testing.T) {
+	api := pod("api-7d9f8b6c4-abcde", time.Hour, v1.PodRunning, true)
+	api.Namespace = "dev"
+	api.Annotations = map[string]string{"kubectl.kubernetes.io/default-container": "api"}
+	api.Spec.Containers = []v1.Container{{Name: "istio-proxy"}, {Name: "api"}}
+	kube := &cluster{client: fake.NewSimpleClientset(&api)}
+	ctx := context.Background()
+
+	var out bytes.Buffer
+	c := &configuration.Pod{ID: "api", Name: "api", Namespace: "dev"}
+	streams := remotecommand.StreamOptions{Stdin: &bytes.Buffer{}, Stdout: &out, Stderr: &bytes.Buffer{}, Tty: true}
+	assert.NoError(t, runner.Exec(ctx, kube, c, nil, streams))
+	assert.Equal(t, "executed", out.String())
+	assert.Equal(t, "api-7d9f8b6c4-abcde", kube.execPod)
+	assert.Equal(t, &v1.PodExecOptions{Container: "api", Command: []string{"sh"}, Stdin: true, Stdout: true, TTY: true}, kube.exec)
+
+	c.Container = "istio-proxy"
+	streams.Tty = false
+	assert.NoError(t, runner.Exec(ctx, kube, c, []string{"ls", "/"}, streams))
+	assert.Equal(t, &v1.PodExecOptions{Container: "istio-proxy", Command: []string{"ls", "/"}, Stdin: true, Stdout: true, Stderr: true}, kube.exec)
+
+	api.Annotations = nil
+	assert.Equal(t, "istio-proxy", runner.ExecContainer(&api, &configuration.Pod{}))
+
+	c = &configuration.Pod{ID: "web", Name: "web", Namespace: "dev"}
+	assert.Error(t, runner.Exec(ctx, kube, c, nil, streams))
+}
+
+func TestKeys(t *testing.T) {
+	terminal, typed := io.Pipe()
+	keys := runner.ReadKeys(terminal)
+	go func() { _, _ = typed.Write([]byte("e")) }()
+	key, ok := keys.Next()
+	assert.True(t, ok)
+	assert.Equal(t, byte('e'), key)
+
+	// An exec gets the keys until it exits
+	input, stop := keys.Input()
+	go func() { _, _ = typed.Write([]byte("ls\n")) }()
+	line := make([]byte, 3)
+	_, err := io.ReadFull(input, line)
+	assert.NoError(t, err)
+	assert.Equal(t, "ls\n", string(line))
+
+	// Its input is still read after it exits: the next key goes to the session
+	read := make(chan error)
+	go func() {
+		_, err := input.Read(make([]byte, 1))
+		read <- err
+	}()
+	stop()
+	assert.Equal(t, io.EOF, <-read)
+	go func() { _, _ = typed.Write([]byte("x")) }()
+	key, ok = keys.Next()
+	assert.True(t, ok)
+	assert.Equal(t, byte('x'), key)
+
+	assert.NoError(t, typed.Close())
+	_, ok = keys.Next()
+	assert.False(t, ok)
 }
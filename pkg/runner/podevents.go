@@ -0,0 +1,260 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AntoineToussaint/kommence/pkg/output"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	apiwatch "k8s.io/apimachinery/pkg/watch"
+)
+
+// sourcesRefresh is how often the objects whose events are reported are looked up again.
+const sourcesRefresh = 10 * time.Second
+
+// eventSource is an object whose events are reported.
+type eventSource struct {
+	Kind string
+	Name string
+}
+
+// followEvents reports the events of the target of the configuration until the context is done:
+// its workload, its replicasets and its pods, ready or not. Each object has its own watch.
+func (p *Pod) followEvents(ctx context.Context, rec chan output.Message) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	watches := make(map[eventSource]context.CancelFunc)
+	initial := true
+	for ctx.Err() == nil {
+		sources, err := p.eventSources(ctx)
+		if err != nil {
+			p.logger.Debugf("%v: can't find the sources of events: %v\n", p.ID(), err)
+		} else {
+			// Gone, like the pods of a previous rollout
+			for source, cancel := range watches {
+				if !sources[source] {
+					cancel()
+					delete(watches, source)
+				}
+			}
+		}
+		for source := range sources {
+			if _, ok := watches[source]; ok {
+				continue
+			}
+			watchCtx, cancel := context.WithCancel(ctx)
+			watches[source] = cancel
+			wg.Add(1)
+			// Objects found later are reported from their first event
+			go func(source eventSource, existing bool) {
+				defer wg.Done()
+				p.watchSource(watchCtx, source, existing, rec)
+			}(source, !initial)
+		}
+		initial = false
+		p.wait(ctx, sourcesRefresh)
+	}
+}
+
+// watchSource reports the events of an object from now on, or all of them if existing.
+func (p *Pod) watchSource(ctx context.Context, source eventSource, existing bool, rec chan output.Message) {
+	selector := fields.Set{"involvedObject.kind": source.Kind, "involvedObject.name": source.Name}.AsSelector().String()
+	events := p.kube.Client().CoreV1().Events(p.config.Namespace)
+	report := func(event *v1.Event) {
+		// In case the server doesn't filter
+		if event.InvolvedObject.Kind == source.Kind && event.InvolvedObject.Name == source.Name {
+			p.event(rec, event)
+		}
+	}
+	version := ""
+	for ctx.Err() == nil {
+		if version == "" {
+			list, err := events.List(ctx, metav1.ListOptions{FieldSelector: selector})
+			if err != nil {
+				p.logger.Debugf("%v: can't list events of %v %v: %v\n", p.ID(), source.Kind, source.Name, err)
+				p.wait(ctx, minReconnectDelay)
+				continue
+			}
+			if existing {
+				for i := range list.Items {
+					report(&list.Items[i])
+				}
+				existing = false
+			}
+			version = list.ResourceVersion
+		}
+		w, err := events.Watch(ctx, metav1.ListOptions{FieldSelector: selector, ResourceVersion: version})
+		if err != nil {
+			p.logger.Debugf("%v: can't watch events of %v %v: %v\n", p.ID(), source.Kind, source.Name, err)
+			version = ""
+			p.wait(ctx, minReconnectDelay)
+			continue
+		}
+		stop := stopOnDone(ctx, w)
+		for e := range w.ResultChan() {
+			if e.Type == apiwatch.Error {
+				// Too old: start over from now once the server closes the watch
+				version = ""
+				continue
+			}
+			event, ok := e.Object.(*v1.Event)
+			if !ok {
+				continue
+			}
+			version = event.ResourceVersion
+			if e.Type != apiwatch.Deleted {
+				report(event)
+			}
+		}
+		// The server closes watches after a while
+		stop()
+	}
+}
+
+// stopOnDone stops the watch when the context is done, or when stopped.
+func stopOnDone(ctx context.Context, w apiwatch.Interface) (stop func()) {
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stopped:
+		}
+		w.Stop()
+	}()
+	return func() {
+		close(stopped)
+	}
+}
+
+// eventSources returns the objects whose events are reported: the workload of the configuration and its
+// replicasets, and the pods of the configuration, ready or not, with their controllers.
+func (p *Pod) eventSources(ctx context.Context) (map[eventSource]bool, error) {
+	client, c := p.kube.Client(), p.config
+	sources := make(map[eventSource]bool)
+	switch {
+	case c.Pod != "":
+		sources[eventSource{"Pod", c.Pod}] = true
+	case c.StatefulSet != "":
+		sources[eventSource{"StatefulSet", c.StatefulSet}] = true
+	case c.Deployment != "":
+		sources[eventSource{"Deployment", c.Deployment}] = true
+		// Replicasets without pods report why they can't create them
+		d, err := client.AppsV1().Deployments(c.Namespace).Get(ctx, c.Deployment, metav1.GetOptions{})
+		if err != nil {
+			return sources, fmt.Errorf("can't get deployment %v: %v", c.Deployment, err)
+		}
+		selector, err := fromLabelSelector(d.Spec.Selector)
+		if err != nil {
+			return sources, err
+		}
+		list, err := client.AppsV1().ReplicaSets(c.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return sources, fmt.Errorf("can't list replicasets of deployment %v: %v", c.Deployment, err)
+		}
+		for i := range list.Items {
+			if owner := metav1.GetControllerOf(&list.Items[i]); owner != nil && owner.UID == d.UID {
+				sources[eventSource{"ReplicaSet", list.Items[i].Name}] = true
+			}
+		}
+	}
+	pods, err := candidates(ctx, client, c)
+	if err != nil {
+		return sources, err
+	}
+	replicaSets := make(map[string]bool)
+	for i := range pods {
+		sources[eventSource{"Pod", pods[i].Name}] = true
+		owner := metav1.GetControllerOf(&pods[i])
+		if owner == nil {
+			continue
+		}
+		sources[eventSource{owner.Kind, owner.Name}] = true
+		if owner.Kind != "ReplicaSet" || replicaSets[owner.Name] {
+			continue
+		}
+		replicaSets[owner.Name] = true
+		rs, err := client.AppsV1().ReplicaSets(c.Namespace).Get(ctx, owner.Name, metav1.GetOptions{})
+		if err != nil {
+			return sources, fmt.Errorf("can't get replicaset %v: %v", owner.Name, err)
+		}
+		if deployment := metav1.GetControllerOf(rs); deployment != nil {
+			sources[eventSource{deployment.Kind, deployment.Name}] = true
+		}
+	}
+	return sources, nil
+}
+
+// event reports an event, warnings apart.
+func (p *Pod) event(rec chan output.Message, e *v1.Event) {
+	t := output.PodEvent
+	if e.Type == v1.EventTypeWarning {
+		t = output.PodWarning
+	}
+	content := fmt.Sprintf("%v %v/%v: %v", e.Reason, strings.ToLower(e.InvolvedObject.Kind), e.InvolvedObject.Name, strings.TrimSpace(e.Message))
+	if e.Count > 1 {
+		content += fmt.Sprintf(" (x%d)", e.Count)
+	}
+	rec <- output.Message{ID: p.ID(), Type: t, Content: content}
+}
+
+// watchContainers reports the containers of the pod that restart after being killed or crashing, like OOMKilled.
+func (p *Pod) watchContainers(ctx context.Context, pod v1.Pod, rec chan output.Message) {
+	restarts := make(map[string]int32)
+	for _, s := range containerStatuses(pod) {
+		restarts[s.Name] = s.RestartCount
+	}
+	version := pod.ResourceVersion
+	for ctx.Err() == nil {
+		w, err := p.kube.Client().CoreV1().Pods(pod.Namespace).Watch(ctx, metav1.ListOptions{
+			FieldSelector:   fields.OneTermEqualSelector("metadata.name", pod.Name).String(),
+			ResourceVersion: version,
+		})
+		if err != nil {
+			p.logger.Debugf("%v: can't watch pod %v: %v\n", p.ID(), pod.Name, err)
+			return
+		}
+		stop := stopOnDone(ctx, w)
+		for e := range w.ResultChan() {
+			if e.Type == apiwatch.Error {
+				version = ""
+				continue
+			}
+			updated, ok := e.Object.(*v1.Pod)
+			if !ok || updated.Name != pod.Name {
+				continue
+			}
+			if e.Type == apiwatch.Deleted {
+				stop()
+				return
+			}
+			version = updated.ResourceVersion
+			for _, s := range containerStatuses(*updated) {
+				if s.RestartCount > restarts[s.Name] {
+					if t := s.LastTerminationState.Terminated; t != nil && t.Reason != "Completed" {
+						content := fmt.Sprintf("%v container %v: exit code %d, restarted %d times", t.Reason, s.Name, t.ExitCode, s.RestartCount)
+						rec <- output.Message{ID: p.ID(), Type: output.PodWarning, Content: content}
+					}
+				}
+				restarts[s.Name] = s.RestartCount
+			}
+		}
+		stop()
+	}
+}
+
+func containerStatuses(pod v1.Pod) []v1.ContainerStatus {
+	return append(append([]v1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+}
+
+// wait for a delay or until the context is done.
+func (p *Pod) wait(ctx context.Context, delay time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(delay):
+	}
+}
@@ -0,0 +1,173 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/AntoineToussaint/kommence/pkg/output"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// followLogs streams the logs of the pod until the context is done.
+// When the pod goes away, the logs of the pod selected again are followed from their beginning.
+func (p *Pod) followLogs(ctx context.Context, pod v1.Pod, rec chan output.Message) {
+	first := true
+	for {
+		p.followPod(ctx, pod, first, rec)
+		first = false
+		selected, ok := p.waitPod(ctx)
+		if !ok {
+			return
+		}
+		if selected.UID != pod.UID {
+			p.connection(rec, "following the logs of pod %v", selected.Name)
+		}
+		pod = selected
+	}
+}
+
+// followPod streams the logs of the pod, and watches its containers if events are enabled, until the pod is gone.
+func (p *Pod) followPod(ctx context.Context, pod v1.Pod, first bool, rec chan output.Message) {
+	if !p.config.Events {
+		p.streamPod(ctx, pod, first, rec)
+		return
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	watched := make(chan struct{})
+	go func() {
+		defer close(watched)
+		p.watchContainers(ctx, pod, rec)
+	}()
+	p.streamPod(ctx, pod, first, rec)
+	cancel()
+	<-watched
+}
+
+// streamPod streams the logs of the containers until the pod is gone.
+func (p *Pod) streamPod(ctx context.Context, pod v1.Pod, first bool, rec chan output.Message) {
+	containers, initContainers, errs := p.containers(pod)
+	for _, err := range errs {
+		rec <- output.Message{ID: p.ID(), Type: output.Error, Content: err.Error()}
+	}
+	if len(containers)+len(initContainers) == 0 {
+		// Nothing to follow until the pod is replaced
+		p.watchPod(ctx, pod, rec, func(string) {})
+		return
+	}
+	// Tell the containers apart
+	prefixed := len(containers)+len(initContainers) > 1
+	var wg sync.WaitGroup
+	for _, c := range append(initContainers, containers...) {
+		opts := &v1.PodLogOptions{Container: c, Follow: true, Timestamps: p.config.Logs.Timestamps}
+		// The pod selected again is followed from its beginning
+		if first {
+			opts.TailLines = p.config.Logs.Tail
+			if since := p.config.Logs.GetSince(); since > 0 {
+				seconds := int64(since.Seconds())
+				opts.SinceSeconds = &seconds
+			}
+		}
+		prefix := ""
+		if prefixed {
+			prefix = fmt.Sprintf("[%v] ", c)
+		}
+		// Init containers run once
+		once := contains(initContainers, c)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.streamContainer(ctx, pod, opts, prefix, once, rec)
+		}()
+	}
+	wg.Wait()
+}
+
+// containers returns the containers and the init containers whose logs are followed,
+// and the errors about the chosen ones that can't be.
+func (p *Pod) containers(pod v1.Pod) ([]string, []string, []error) {
+	var containers, initContainers, allInit []string
+	for _, c := range pod.Spec.Containers {
+		containers = append(containers, c.Name)
+	}
+	for _, c := range pod.Spec.InitContainers {
+		allInit = append(allInit, c.Name)
+	}
+	if p.config.Logs.InitContainers {
+		initContainers = allInit
+	}
+	chosen := p.config.Logs.Containers
+	if len(chosen) == 0 && p.config.Container != "" {
+		chosen = []string{p.config.Container}
+	}
+	if len(chosen) == 0 {
+		return containers, initContainers, nil
+	}
+	var selected, selectedInit []string
+	var errs []error
+	for _, c := range chosen {
+		switch {
+		case contains(containers, c):
+			selected = append(selected, c)
+		case contains(initContainers, c):
+			selectedInit = append(selectedInit, c)
+		case contains(allInit, c):
+			errs = append(errs, fmt.Errorf("%v is an init container of pod %v: its logs need initContainers", c, pod.Name))
+		default:
+			errs = append(errs, fmt.Errorf("pod %v has no container %v", pod.Name, c))
+		}
+	}
+	return selected, selectedInit, errs
+}
+
+func contains(s []string, x string) bool {
+	for _, e := range s {
+		if e == x {
+			return true
+		}
+	}
+	return false
+}
+
+// streamContainer streams the logs of a container, and of its next runs while the pod exists.
+func (p *Pod) streamContainer(ctx context.Context, pod v1.Pod, opts *v1.PodLogOptions, prefix string, once bool, rec chan output.Message) {
+	pods := p.kube.Client().CoreV1().Pods(pod.Namespace)
+	failing := false
+	for {
+		stream, err := pods.GetLogs(pod.Name, opts).Stream(ctx)
+		streamed := err == nil
+		switch {
+		case streamed:
+			failing = false
+			exportLines(output.NewLineBreaker(rec, p.ID(), output.Log, output.WithPrefix(prefix)), stream)
+			_ = stream.Close()
+			if once {
+				return
+			}
+		case apierrors.IsBadRequest(err) || ctx.Err() != nil || failing:
+			// Containers waiting to start have no logs yet
+			p.logger.Debugf("%v: can't stream logs of %v: %v\n", p.ID(), opts.Container, err)
+		default:
+			// Like Forbidden: reported once until it works
+			failing = true
+			rec <- output.Message{ID: p.ID(), Type: output.Error, Content: fmt.Sprintf("can't stream logs of container %v: %v", opts.Container, err)}
+		}
+		ended := metav1.Now()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+		current, err := pods.Get(ctx, pod.Name, metav1.GetOptions{})
+		if err != nil || current.UID != pod.UID || current.DeletionTimestamp != nil {
+			return
+		}
+		// The container restarted, or the stream was cut: continue from there
+		if streamed {
+			opts = &v1.PodLogOptions{Container: opts.Container, Follow: true, Timestamps: opts.Timestamps, SinceTime: &ended}
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package runner
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/AntoineToussaint/kommence/pkg/configuration"
+	"github.com/AntoineToussaint/kommence/pkg/ports"
+)
+
+// allocatePorts checks the ports of the tasks about to start, by configuration ID, and reserves the automatic ones
+// until the tasks release them. Fixed ports of the other configurations can be referenced too.
+// The tasks that can't get their ports are returned with the reason.
+func allocatePorts(config *configuration.Configuration, tasks map[string]configuration.Ports) (ports.Table, map[string][]*ports.Reservation, map[string]error) {
+	table := ports.Table{}
+	if config.Execs != nil {
+		for id, c := range config.Execs.Commands {
+			fixed(table, id, c.Ports)
+		}
+	}
+	if config.Pods != nil {
+		for id, c := range config.Pods.Pods {
+			fixed(table, id, c.GetPorts())
+		}
+	}
+
+	reserved := make(map[string][]*ports.Reservation)
+	blocked := make(map[string]error)
+	// Who uses which port in this session
+	owners := make(map[int]string)
+	for _, id := range sortedKeys(tasks) {
+		for _, name := range sortedKeys(tasks[id]) {
+			port := tasks[id][name]
+			if port.Auto {
+				r, err := ports.Reserve()
+				if err != nil {
+					blocked[id] = err
+					break
+				}
+				reserved[id] = append(reserved[id], r)
+				table.Set(id, name, r.Port)
+				owners[r.Port] = id
+				continue
+			}
+			if owner, ok := owners[port.Number]; ok {
+				blocked[id] = fmt.Errorf("%v: port %v is also used by %v", name, port.Number, owner)
+				break
+			}
+			owners[port.Number] = id
+			if err := ports.Check(port.Number); err != nil {
+				blocked[id] = fmt.Errorf("%v: %v", name, err)
+				break
+			}
+		}
+	}
+	return table, reserved, blocked
+}
+
+func fixed(table ports.Table, id string, p configuration.Ports) {
+	for name, port := range p {
+		if !port.Auto {
+			table.Set(id, name, port.Number)
+		}
+	}
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// withPorts returns a copy of the configuration with ${ports.<task>.<name>} replaced in its commands and env.
+func withPorts(c *configuration.Executable, table ports.Table) (*configuration.Executable, error) {
+	resolved := *c
+	var err error
+	interpolate := func(s string) string {
+		if err != nil {
+			return s
+		}
+		s, err = table.Interpolate(s)
+		return s
+	}
+	resolved.Cmd = interpolate(c.Cmd)
+	resolved.Build = interpolate(c.Build)
+	resolved.Env = make(map[string]string)
+	for k, v := range c.Env {
+		resolved.Env[k] = interpolate(v)
+	}
+	hooks := func(hooks []configuration.Hook) []configuration.Hook {
+		var resolved []configuration.Hook
+		for _, hook := range hooks {
+			hook.Cmd = interpolate(hook.Cmd)
+			resolved = append(resolved, hook)
+		}
+		return resolved
+	}
+	resolved.BeforeStart = hooks(c.BeforeStart)
+	resolved.AfterStart = hooks(c.AfterStart)
+	resolved.BeforeStop = hooks(c.BeforeStop)
+	resolved.AfterStop = hooks(c.AfterStop)
+	return &resolved, err
+}
+
+// podWithPorts returns a copy of the configuration forwarding the allocated local ports.
+func podWithPorts(c *configuration.Pod, table ports.Table) *configuration.Pod {
+	resolved := *c
+	resolved.Ports = nil
+	for _, f := range c.Forwards() {
+		if port, ok := table[c.ID][f.Name]; ok {
+			f.Local = configuration.Port{Number: port}
+		}
+		resolved.Ports = append(resolved.Ports, f)
+	}
+	resolved.LocalPort, resolved.PodPort = configuration.Port{}, ""
+	return &resolved
+}
+
+// release the reserved ports for the task to bind them.
+func release(reserved []*ports.Reservation) {
+	for _, r := range reserved {
+		r.Release()
+	}
+}
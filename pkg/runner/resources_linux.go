@@ -0,0 +1,145 @@
+//go:build linux
+
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AntoineToussaint/kommence/pkg/output"
+)
+
+// clockTicks is USER_HZ, which is 100 on every Linux we care about.
+const clockTicks = 100
+
+// sampler measures the resources of a process group from /proc.
+type sampler struct {
+	pgid      int
+	lastTicks uint64
+	lastTime  time.Time
+}
+
+func newSampler(pgid int) *sampler {
+	return &sampler{pgid: pgid}
+}
+
+// Sample the whole process group.
+func (s *sampler) Sample() (output.Resources, error) {
+	var res output.Resources
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return res, err
+	}
+	var ticks uint64
+	found := false
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		// Processes come and go: ignore the ones we can't read
+		st, err := readStat(pid)
+		if err != nil || st.pgrp != s.pgid {
+			continue
+		}
+		found = true
+		ticks += st.utime + st.stime
+		res.Threads += st.threads
+		if mem, err := calculateMemory(pid); err == nil {
+			res.Memory += mem * 1024
+		}
+		if fds, err := os.ReadDir(fmt.Sprintf("/proc/%d/fd", pid)); err == nil {
+			res.FDs += len(fds)
+		}
+	}
+	if !found {
+		return res, fmt.Errorf("no process in group %d", s.pgid)
+	}
+	now := time.Now()
+	if !s.lastTime.IsZero() && ticks >= s.lastTicks {
+		elapsed := now.Sub(s.lastTime).Seconds()
+		if elapsed > 0 {
+			res.CPU = float64(ticks-s.lastTicks) / clockTicks / elapsed * 100
+		}
+	}
+	s.lastTicks, s.lastTime = ticks, now
+	return res, nil
+}
+
+type stat struct {
+	pgrp    int
+	utime   uint64
+	stime   uint64
+	threads int
+}
+
+func readStat(pid int) (stat, error) {
+	var st stat
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return st, err
+	}
+	// The command name can contain anything: skip after the last parenthesis
+	i := bytes.LastIndexByte(data, ')')
+	if i < 0 {
+		return st, fmt.Errorf("invalid stat for %d", pid)
+	}
+	// Fields start at the state, which is field 3 in proc(5)
+	fields := strings.Fields(string(data[i+1:]))
+	if len(fields) < 18 {
+		return st, fmt.Errorf("invalid stat for %d", pid)
+	}
+	field := func(n int) string {
+		return fields[n-3]
+	}
+	if st.pgrp, err = strconv.Atoi(field(5)); err != nil {
+		return st, err
+	}
+	if st.utime, err = strconv.ParseUint(field(14), 10, 64); err != nil {
+		return st, err
+	}
+	if st.stime, err = strconv.ParseUint(field(15), 10, 64); err != nil {
+		return st, err
+	}
+	if st.threads, err = strconv.Atoi(field(20)); err != nil {
+		return st, err
+	}
+	return st, nil
+}
+
+// calculateMemory returns the PSS of a process in kB.
+func calculateMemory(pid int) (uint64, error) {
+	// smaps_rollup is much cheaper when available
+	f, err := os.Open(fmt.Sprintf("/proc/%d/smaps_rollup", pid))
+	if err != nil {
+		f, err = os.Open(fmt.Sprintf("/proc/%d/smaps", pid))
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	res := uint64(0)
+	pfx := []byte("Pss:")
+	r := bufio.NewScanner(f)
+	for r.Scan() {
+		line := r.Bytes()
+		if bytes.HasPrefix(line, pfx) {
+			var size uint64
+			_, err := fmt.Sscanf(string(line[4:]), "%d", &size)
+			if err != nil {
+				return 0, err
+			}
+			res += size
+		}
+	}
+	if err := r.Err(); err != nil {
+		return 0, err
+	}
+	return res, nil
+}
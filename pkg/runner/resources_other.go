@@ -0,0 +1,20 @@
+//go:build !linux
+
+package runner
+
+import (
+	"fmt"
+
+	"github.com/AntoineToussaint/kommence/pkg/output"
+)
+
+// sampler is only implemented on Linux.
+type sampler struct{}
+
+func newSampler(pgid int) *sampler {
+	return &sampler{}
+}
+
+func (s *sampler) Sample() (output.Resources, error) {
+	return output.Resources{}, fmt.Errorf("resource monitoring is only supported on Linux")
+}
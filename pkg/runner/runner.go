@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"github.com/AntoineToussaint/kommence/pkg/configuration"
 	"github.com/AntoineToussaint/kommence/pkg/output"
+	"github.com/AntoineToussaint/kommence/pkg/ports"
 	"github.com/fatih/color"
+	"sort"
 	"strings"
+	"sync"
 )
 
 type Runner struct {
@@ -14,12 +17,51 @@ type Runner struct {
 	Configuration *configuration.Configuration
 	Logger        *output.Logger
 	tasks         []Runnable
+	// flows whose before_start hooks succeeded
+	flows []*configuration.Flow
+
+	mu     sync.Mutex
+	status map[string]*TaskStatus
+	// completed are closed when a job first completes
+	completed map[string]chan struct{}
+	// dependents of a job
+	dependents map[string][]string
+	attached   *attachment
+}
+
+// Task states
+const (
+	Starting    = "starting"
+	Running     = "running"
+	Restarting  = "restarting"
+	Stopped     = "stopped"
+	Building    = "building"
+	BuildFailed = "build failed"
+	Waiting     = "waiting"
+	Completed   = "completed"
+	Failed      = "failed"
+	Blocked     = "blocked"
+)
+
+// TaskStatus is the last known state of a task.
+type TaskStatus struct {
+	ID        string
+	State     string
+	Resources *output.Resources `json:",omitempty"`
+	// ExitCode of a completed or failed job
+	ExitCode *int `json:",omitempty"`
+	// Ports of the task, by name
+	Ports map[string]int `json:",omitempty"`
+	// BlockedBy is the failed job a blocked task depends on
+	BlockedBy string `json:",omitempty"`
 }
 
 type Runtime struct {
 	Executables    []string
 	Pods           []string
+	Flows          []string
 	KubeConfigPath string
+	KubeContext    string
 }
 
 type Runnable interface {
@@ -33,9 +75,116 @@ func New(log *output.Logger, c *configuration.Configuration) *Runner {
 		Logger:        log,
 		Configuration: c,
 		Receiver:      make(chan output.Message),
+		status:        make(map[string]*TaskStatus),
+		completed:     make(map[string]chan struct{}),
+		dependents:    make(map[string][]string),
+	}
+}
+
+// Status of all tasks, sorted by ID.
+func (r *Runner) Status() []TaskStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var status []TaskStatus
+	for _, s := range r.status {
+		status = append(status, *s)
+	}
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].ID < status[j].ID
+	})
+	return status
+}
+
+// track updates the status of a task from its messages.
+func (r *Runner) track(msg output.Message) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.status[msg.ID]
+	if !ok {
+		return
+	}
+	switch msg.Type {
+	case output.Log, output.PodConnection:
+		s.State = Running
+	case output.Memory, output.CPU:
+		s.State = Running
+		s.Resources = msg.Resources
+	case output.Restart:
+		s.State = Restarting
+		s.Resources = nil
+	case output.Stop:
+		// A job that ran to completion stays so
+		if s.State != Completed && s.State != Failed {
+			s.State = Stopped
+		}
+		s.Resources = nil
+	case output.Blocked:
+		s.State = Blocked
+	case output.Build, output.BuildError:
+		if s.State == Starting {
+			s.State = Building
+		}
+	case output.BuildFailed:
+		// A failed rebuild keeps the previous process running
+		if s.State == Starting || s.State == Building {
+			s.State = BuildFailed
+		}
+	case output.Completed, output.Failed:
+		s.State = Completed
+		if msg.Type == output.Failed {
+			s.State = Failed
+		}
+		s.Resources = nil
+		code := msg.ExitCode
+		s.ExitCode = &code
+		if msg.Type == output.Completed {
+			// Its dependents wait for it again: they start with it
+			for _, id := range r.dependents[msg.ID] {
+				if d, ok := r.status[id]; ok && d.State == Blocked && d.BlockedBy == msg.ID {
+					d.State, d.BlockedBy = Waiting, ""
+				}
+			}
+		}
+		if ch, ok := r.completed[msg.ID]; ok && msg.Type == output.Completed {
+			select {
+			case <-ch:
+			default:
+				close(ch)
+			}
+		}
+	}
+}
+
+// addTask to the session, tasks can be looked up while the session starts.
+func (r *Runner) addTask(task Runnable) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tasks = append(r.tasks, task)
+}
+
+// setState of a task if it's in the expected state.
+func (r *Runner) setState(id string, expected string, state string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if s, ok := r.status[id]; ok && s.State == expected {
+		s.State = state
 	}
 }
 
+// block the dependents of a failed job that are still waiting, and return them.
+func (r *Runner) block(job string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var blocked []string
+	for _, id := range r.dependents[job] {
+		if s, ok := r.status[id]; ok && s.State == Waiting {
+			s.State, s.BlockedBy = Blocked, job
+			blocked = append(blocked, id)
+		}
+	}
+	return blocked
+}
+
 type PaddedID struct {
 	Length int
 }
@@ -48,29 +197,126 @@ func (p *PaddedID) ID(id string) string {
 	return id + padding
 }
 
-const tmpl = `{{if .Timestamp}} [{{.Timestamp}}]{{end}}{{if .Level}} [{{.Level}}]{{end}} {{.Parsed}}`
+const tmpl = `{{if .Timestamp}} {{.Timestamp}}{{end}}{{if .Level}} {{.Level}}{{end}} {{.Parsed}}`
+
+// render a parsed log: the level tag gets the color of the level and highlight rules apply to the message.
+func render(log *output.Logger, parsed output.StructuredLog, style output.Style, highlighter *output.Highlighter) string {
+	styled := output.StructuredLog{Parsed: highlighter.Apply(parsed.Parsed, style)}
+	if parsed.Timestamp != "" {
+		styled.Timestamp = output.Colorize("["+parsed.Timestamp+"]", style)
+	}
+	if parsed.Level != "" {
+		styled.Level = output.Colorize("["+parsed.Level+"]", output.LevelStyle(parsed.Level, style))
+	}
+	return output.FromTemplate(log, tmpl, styled)
+}
+
+// highlighter for a task: its own rules take precedence over the global ones.
+func (r *Runner) highlighter(styler *output.Styler, own []configuration.Highlight) *output.Highlighter {
+	highlights := append([]configuration.Highlight{}, own...)
+	if r.Configuration.Settings != nil {
+		highlights = append(highlights, r.Configuration.Settings.Highlight...)
+	}
+	h := &output.Highlighter{}
+	for _, highlight := range highlights {
+		// Rules are validated when loading the configuration
+		rule, err := output.NewHighlightRule(styler, highlight.Pattern, highlight.Style)
+		if err != nil {
+			r.Logger.Errorf("ignoring highlight: %v\n", err)
+			continue
+		}
+		h.Rules = append(h.Rules, rule)
+	}
+	return h
+}
 
 func (r *Runner) Run(ctx context.Context, cfg *Runtime) error {
-	var styler output.Styler
+	styler := output.NewStyler()
 	styles := make(map[string]output.Style)
+	colors := make(map[string]string)
+	highlighters := make(map[string]*output.Highlighter)
+	// ansi are the tasks whose output may contain ANSI sequences
+	ansi := make(map[string]bool)
+	keepColors := make(map[string]bool)
+
+	// Dependencies are started too
+	executables := r.Configuration.Execs.WithDependencies(cfg.Executables)
 
-	for _, executable := range cfg.Executables {
+	// Ports are checked and allocated before anything starts
+	declared := make(map[string]configuration.Ports)
+	for _, executable := range executables {
 		if c, ok := r.Configuration.Execs.Get(executable); ok {
-			exec := NewExecutable(r.Logger, c)
-			r.tasks = append(r.tasks, exec)
+			declared[c.ID] = c.Ports
+		}
+	}
+	for _, pod := range cfg.Pods {
+		if c, ok := r.Configuration.Pods.Get(pod); ok {
+			declared[c.ID] = c.GetPorts()
 		}
 	}
+	table, reserved, conflicts := allocatePorts(r.Configuration, declared)
+	// Reserved ports of the tasks, released if they don't start
+	taskReserved := make(map[string][]*ports.Reservation)
+	// blocked tasks don't start
+	blocked := make(map[string]error)
+	taskPorts := make(map[string]map[string]int)
 
-	// Load Kubernetes client
-	if len(cfg.Pods) > 0 {
-		r.Logger.Debugf("loading kubernetes client\n")
-		LoadKubeClient(cfg.KubeConfigPath)
+	ids := make(map[string]string)
+	dependencies := make(map[string][]string)
+	for _, executable := range executables {
+		if c, ok := r.Configuration.Execs.Get(executable); ok {
+			resolved, err := withPorts(c, table)
+			exec := newExecutable(r.Logger, resolved)
+			exec.reserved = reserved[c.ID]
+			taskReserved[exec.ID()] = reserved[c.ID]
+			r.addTask(exec)
+			if err == nil {
+				err = conflicts[c.ID]
+			}
+			if err != nil {
+				blocked[exec.ID()] = err
+			}
+			taskPorts[exec.ID()] = table[c.ID]
+			colors[exec.ID()] = c.Color
+			highlighters[exec.ID()] = r.highlighter(styler, c.Highlight)
+			ansi[exec.ID()] = c.TTY || c.KeepColors
+			keepColors[exec.ID()] = c.KeepColors
+			ids[c.ID] = exec.ID()
+			if c.IsJob() {
+				r.completed[exec.ID()] = make(chan struct{})
+			}
+			for _, d := range c.DependsOn {
+				if dep, ok := r.Configuration.Execs.Get(d); ok {
+					// Jobs come first
+					job := ids[dep.ID]
+					dependencies[exec.ID()] = append(dependencies[exec.ID()], job)
+					r.dependents[job] = append(r.dependents[job], exec.ID())
+				}
+			}
+		}
 	}
 
+	kubes := NewKubeLoader(cfg.KubeConfigPath, cfg.KubeContext)
 	for _, pod := range cfg.Pods {
 		if c, ok := r.Configuration.Pods.Get(pod); ok {
-			exec := NewPod(r.Logger, c)
-			r.tasks = append(r.tasks, exec)
+			r.Logger.Debugf("loading kubernetes client for %v\n", c.ID)
+			var cluster Cluster
+			kube, kubeErr := kubes.Load(c.Context)
+			if kubeErr == nil {
+				cluster = kube
+			}
+			exec := newPod(r.Logger, podWithPorts(c, table), cluster)
+			exec.reserved = reserved[c.ID]
+			taskReserved[exec.ID()] = reserved[c.ID]
+			r.addTask(exec)
+			if err := conflicts[c.ID]; err != nil {
+				blocked[exec.ID()] = err
+			} else if kubeErr != nil {
+				blocked[exec.ID()] = kubeErr
+			}
+			taskPorts[exec.ID()] = table[c.ID]
+			colors[exec.ID()] = c.Color
+			highlighters[exec.ID()] = r.highlighter(styler, nil)
 		}
 	}
 
@@ -81,33 +327,118 @@ func (r *Runner) Run(ctx context.Context, cfg *Runtime) error {
 
 	// Figure out padding and styles
 	maxIDLength := 0
+	r.mu.Lock()
+	for _, task := range r.tasks {
+		r.status[task.ID()] = &TaskStatus{ID: task.ID(), State: Starting, Ports: taskPorts[task.ID()]}
+		if len(dependencies[task.ID()]) > 0 {
+			r.status[task.ID()].State = Waiting
+		}
+	}
+	r.mu.Unlock()
 	for _, start := range r.tasks {
 		if l := len(start.ID()); l > maxIDLength {
 			maxIDLength = l
 		}
-		styles[start.ID()] = styler.Next()
+		styles[start.ID()] = styler.For(start.ID())
+		// Colors are validated when loading the configuration
+		if style, _ := styler.Parse(colors[start.ID()]); style != nil {
+			styles[start.ID()] = style
+		}
 	}
 	padding := PaddedID{Length: maxIDLength}
 
+	// mark prints a message of a task after a mark, in the style of a level if set
+	mark := func(id string, sign string, content string, level string) {
+		text := " " + content
+		if level != "" {
+			text = output.Colorize(text, output.LevelStyle(level, nil))
+		}
+		r.print(id, content, output.Colorize(padding.ID(id)+" "+sign, styles[id])+text)
+	}
 	go func() {
 		for msg := range r.Receiver {
+			r.track(msg)
+			style := styles[msg.ID]
+			prefix := padding.ID(msg.ID)
+			switch msg.Type {
+			case output.Error, output.BuildFailed:
+				mark(msg.ID, "!", msg.Content, "ERROR")
+				continue
+			case output.Failed, output.Blocked:
+				// A job that can't start blocks its dependents too
+				mark(msg.ID, "!", msg.Content, "ERROR")
+				for _, blocked := range r.block(msg.ID) {
+					mark(blocked, "!", "blocked: "+msg.ID+" failed", "ERROR")
+				}
+				continue
+			case output.Build:
+				mark(msg.ID, "#", msg.Content, "")
+				continue
+			case output.BuildError:
+				mark(msg.ID, "#", msg.Content, "ERROR")
+				continue
+			case output.Built, output.Completed:
+				mark(msg.ID, "#", msg.Content, "INFO")
+				continue
+			case output.PodWarning:
+				mark(msg.ID, "!", msg.Content, "WARN")
+				continue
+			case output.PodEvent:
+				mark(msg.ID, "~", msg.Content, "")
+				continue
+			}
 			if msg.Type != output.Log {
 				continue
 			}
+			content := msg.Content
+			if ansi[msg.ID] {
+				if keepColors[msg.ID] && !color.NoColor {
+					r.print(msg.ID, msg.Content, output.Colorize(prefix+" >", style)+" "+content+output.ANSIReset)
+					continue
+				}
+				content = output.StripANSI(content)
+			}
 			// Parse message
-			parsed := output.ParseToStructured(msg.Content)
-			// Render it
-			rendered := output.FromTemplate(r.Logger, tmpl, parsed)
-			// Style it
-			style := styles[msg.ID]
+			parsed := output.ParseToStructured(content)
+			// Style and render it
+			rendered := render(r.Logger, parsed, style, highlighters[msg.ID])
 			// Regular message
-			r.Logger.Printf(padding.ID(msg.ID)+" >"+rendered+"\n", style...)
+			r.print(msg.ID, msg.Content, output.Colorize(prefix+" >", style)+rendered)
 		}
 	}()
 
+	for _, flow := range cfg.Flows {
+		f, ok := r.Configuration.Flows.Get(flow)
+		if !ok {
+			continue
+		}
+		if err := runHooks(ctx, r.Receiver, flowID(f), "before_start", f.BeforeStart, nil); err != nil {
+			r.Receiver <- output.Message{ID: flowID(f), Type: output.Error, Content: fmt.Sprintf("not starting: %v", err)}
+			return err
+		}
+		r.mu.Lock()
+		r.flows = append(r.flows, f)
+		r.mu.Unlock()
+	}
+
 	errors := make(chan error)
 	for _, task := range r.tasks {
 		go func(s Runnable) {
+			// Wait for the jobs it depends on
+			for _, job := range dependencies[s.ID()] {
+				select {
+				case <-r.completed[job]:
+				case <-ctx.Done():
+					release(taskReserved[s.ID()])
+					return
+				}
+			}
+			r.setState(s.ID(), Waiting, Starting)
+			if err := blocked[s.ID()]; err != nil {
+				release(taskReserved[s.ID()])
+				r.Receiver <- output.Message{ID: s.ID(), Type: output.Blocked, Content: fmt.Sprintf("not starting: %v", err)}
+				return
+			}
 			// Some runnable returns error (Pod) and some don't (Executable)
 			// On error, we should return: stop kommence
 			err := s.Start(ctx, r.Receiver)
@@ -117,6 +448,8 @@ func (r *Runner) Run(ctx context.Context, cfg *Runtime) error {
 			}
 		}(task)
 	}
+	go r.runFlowHooks(ctx, "after_start", func(f *configuration.Flow) []configuration.Hook { return f.AfterStart })
+
 	// Wait for context Done or if we stop
 	for {
 		select {
@@ -130,7 +463,25 @@ func (r *Runner) Run(ctx context.Context, cfg *Runtime) error {
 	}
 }
 
+func flowID(f *configuration.Flow) string {
+	return fmt.Sprintf("🌊 %v", f.ID)
+}
+
+// runFlowHooks runs a stage of hooks for the started flows, reporting failures.
+func (r *Runner) runFlowHooks(ctx context.Context, stage string, hooks func(*configuration.Flow) []configuration.Hook) {
+	r.mu.Lock()
+	flows := r.flows
+	r.mu.Unlock()
+	for _, f := range flows {
+		if err := runHooks(ctx, r.Receiver, flowID(f), stage, hooks(f), nil); err != nil {
+			r.Receiver <- output.Message{ID: flowID(f), Type: output.Error, Content: err.Error()}
+		}
+	}
+}
+
 func (r *Runner) Stop(ctx context.Context) error {
+	// The context is usually done already: hooks only get their timeout
+	r.runFlowHooks(context.Background(), "before_stop", func(f *configuration.Flow) []configuration.Hook { return f.BeforeStop })
 	var errors []string
 	for _, task := range r.tasks {
 		err := task.Stop(ctx, r.Receiver)
@@ -138,6 +489,7 @@ func (r *Runner) Stop(ctx context.Context) error {
 			errors = append(errors, err.Error())
 		}
 	}
+	r.runFlowHooks(context.Background(), "after_stop", func(f *configuration.Flow) []configuration.Hook { return f.AfterStop })
 	if len(errors) > 0 {
 		return fmt.Errorf("can't stop properly: %v", strings.Join(errors, ", "))
 	}
@@ -0,0 +1,155 @@
+package runner_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AntoineToussaint/kommence/pkg/configuration"
+	"github.com/AntoineToussaint/kommence/pkg/output"
+	"github.com/AntoineToussaint/kommence/pkg/ports"
+	"github.com/AntoineToussaint/kommence/pkg/runner"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunnerDependencies(t *testing.T) {
+	// A running runner reads its configuration: each phase has its own
+	dependencies := func(cmd string) *configuration.Configuration {
+		job := &configuration.Executable{ID: "migrate", Kind: configuration.Job, Cmd: cmd, Monitor: configuration.Monitor{Disabled: true}}
+		service := &configuration.Executable{ID: "api", Kind: configuration.Service, Cmd: "sleep 5", DependsOn: []string{"migrate"}, Monitor: configuration.Monitor{Disabled: true}}
+		return &configuration.Configuration{Execs: &configuration.Executables{
+			Commands:  map[string]*configuration.Executable{job.ID: job, service.ID: service},
+			Shortcuts: map[string]*configuration.Executable{},
+		}}
+	}
+
+	log := output.NewLogger(false)
+	r := runner.New(log, dependencies("false"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// The job is started as a dependency
+	go r.Run(ctx, &runner.Runtime{Executables: []string{"api"}})
+	defer r.Stop(ctx)
+
+	// A failed job blocks its dependents
+	assert.Eventually(t, func() bool {
+		status := r.Status()
+		return len(status) == 2 && status[0].State == runner.Blocked && status[1].State == runner.Failed
+	}, 2*time.Second, 10*time.Millisecond)
+	status := r.Status()
+	assert.Equal(t, status[1].ID, status[0].BlockedBy)
+	assert.Equal(t, 1, *status[1].ExitCode)
+
+	// Once it completes, they start
+	r2 := runner.New(log, dependencies("true"))
+	go r2.Run(ctx, &runner.Runtime{Executables: []string{"api"}})
+	defer r2.Stop(ctx)
+	assert.Eventually(t, func() bool {
+		status := r2.Status()
+		return len(status) == 2 && status[0].State == runner.Starting && status[1].State == runner.Completed
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// A job that can't start fails like in shells
+	r3 := runner.New(log, dependencies("kommence-missing-command"))
+	go r3.Run(ctx, &runner.Runtime{Executables: []string{"api"}})
+	defer r3.Stop(ctx)
+	assert.Eventually(t, func() bool {
+		status := r3.Status()
+		return len(status) == 2 && status[0].State == runner.Blocked && status[1].State == runner.Failed
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 127, *r3.Status()[1].ExitCode)
+}
+
+func TestRunnerBlockedByHook(t *testing.T) {
+	job := &configuration.Executable{ID: "migrate", Kind: configuration.Job, Cmd: "true", Monitor: configuration.Monitor{Disabled: true}, Hooks: configuration.Hooks{
+		BeforeStart: []configuration.Hook{{Cmd: "false"}},
+	}}
+	service := &configuration.Executable{ID: "api", Cmd: "sleep 5", DependsOn: []string{"migrate"}, Monitor: configuration.Monitor{Disabled: true}}
+	config := &configuration.Configuration{Execs: &configuration.Executables{
+		Commands:  map[string]*configuration.Executable{job.ID: job, service.ID: service},
+		Shortcuts: map[string]*configuration.Executable{},
+	}}
+
+	r := runner.New(output.NewLogger(false), config)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx, &runner.Runtime{Executables: []string{"api"}})
+	defer r.Stop(ctx)
+
+	// A failing before_start hook blocks the job and its dependents
+	assert.Eventually(t, func() bool {
+		status := r.Status()
+		return len(status) == 2 && status[0].State == runner.Blocked && status[1].State == runner.Blocked
+	}, 2*time.Second, 10*time.Millisecond)
+	status := r.Status()
+	assert.Equal(t, status[1].ID, status[0].BlockedBy)
+}
+
+func TestRunnerPorts(t *testing.T) {
+	l, err := net.Listen("tcp", ":0")
+	assert.NoError(t, err)
+	defer l.Close()
+	used := l.Addr().(*net.TCPAddr).Port
+	script := filepath.Join(t.TempDir(), "web.sh")
+	assert.NoError(t, os.WriteFile(script, []byte("read line\necho \"$API $DB\"\nsleep 5\n"), 0755))
+
+	disabled := configuration.Monitor{Disabled: true}
+	api := &configuration.Executable{ID: "api", Cmd: "sleep 5", Ports: configuration.Ports{"http": {Auto: true}}, Monitor: disabled}
+	db := &configuration.Executable{ID: "db", Cmd: "sleep 5", Ports: configuration.Ports{"sql": {Number: used}}, Monitor: disabled}
+	web := &configuration.Executable{ID: "web", Cmd: "sh " + script, Stdin: true, Monitor: disabled, Env: map[string]string{
+		"API": "localhost:${ports.api.http}",
+		"DB":  "localhost:${ports.db.sql}",
+	}}
+	admin := &configuration.Executable{ID: "admin", Cmd: "sleep 5", Env: map[string]string{"API": "${ports.api.debug}"}, Monitor: disabled}
+	config := &configuration.Configuration{Execs: &configuration.Executables{
+		Commands:  map[string]*configuration.Executable{api.ID: api, db.ID: db, web.ID: web, admin.ID: admin},
+		Shortcuts: map[string]*configuration.Executable{},
+	}}
+
+	r := runner.New(output.NewLogger(false), config)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx, &runner.Runtime{Executables: []string{"admin", "api", "db", "web"}})
+	defer r.Stop(ctx)
+
+	// The port in use and the unknown port block their tasks, the automatic one is allocated
+	tasks := func() map[string]runner.TaskStatus {
+		tasks := make(map[string]runner.TaskStatus)
+		for _, s := range r.Status() {
+			tasks[s.ID[strings.LastIndex(s.ID, " ")+1:]] = s
+		}
+		return tasks
+	}
+	assert.Eventually(t, func() bool {
+		tasks := tasks()
+		return tasks["admin"].State == runner.Blocked && tasks["db"].State == runner.Blocked
+	}, 2*time.Second, 10*time.Millisecond)
+	port := tasks()["api"].Ports["http"]
+	assert.NotZero(t, port)
+
+	// Automatic and fixed ports are referenced by other tasks
+	out := make(chan string, 10)
+	in, _, detach, err := r.Attach("web", out)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer detach()
+	assert.Eventually(t, func() bool {
+		_, err := in.Write([]byte("\n"))
+		return err == nil
+	}, 2*time.Second, 10*time.Millisecond)
+	select {
+	case line := <-out:
+		assert.Equal(t, fmt.Sprintf("localhost:%d localhost:%d", port, used), line)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no output")
+	}
+
+	// Released for the task once it started
+	assert.NoError(t, ports.Check(port))
+}
@@ -0,0 +1,205 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/AntoineToussaint/kommence/pkg/configuration"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+)
+
+// SelectPod finds the pod of a configuration and returns the one to connect to.
+func SelectPod(ctx context.Context, client kubernetes.Interface, c *configuration.Pod) (*v1.Pod, error) {
+	pods, err := candidates(ctx, client, c)
+	if err != nil {
+		return nil, err
+	}
+	pod, ok := ChoosePod(pods)
+	if !ok {
+		return nil, fmt.Errorf("no running and ready pod for %v in namespace %v (%v found)", c.Target(), c.Namespace, len(pods))
+	}
+	return pod, nil
+}
+
+// candidates lists the pods matching the configuration.
+func candidates(ctx context.Context, client kubernetes.Interface, c *configuration.Pod) ([]v1.Pod, error) {
+	pods := client.CoreV1().Pods(c.Namespace)
+	if c.Pod != "" {
+		pod, err := pods.Get(ctx, c.Pod, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("can't get pod %v: %v", c.Pod, err)
+		}
+		return []v1.Pod{*pod}, nil
+	}
+	selector, err := podSelector(ctx, client, c)
+	if err != nil {
+		return nil, err
+	}
+	list, err := pods.List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("can't list pods for %v: %v", c.Target(), err)
+	}
+	if c.Selector != "" || c.Deployment != "" || c.StatefulSet != "" || c.Service != "" {
+		return list.Items, nil
+	}
+	// Name is the prefix of the pods of a deployment or a statefulset
+	var matching []v1.Pod
+	for _, pod := range list.Items {
+		if MatchPod(c.Name, pod.Name) {
+			matching = append(matching, pod)
+		}
+	}
+	return matching, nil
+}
+
+// podSelector returns the label selector of the pods of the configuration, all of them when selecting by name.
+func podSelector(ctx context.Context, client kubernetes.Interface, c *configuration.Pod) (string, error) {
+	switch {
+	case c.Selector != "":
+		if _, err := labels.Parse(c.Selector); err != nil {
+			return "", fmt.Errorf("invalid selector %v: %v", c.Selector, err)
+		}
+		return c.Selector, nil
+	case c.Deployment != "":
+		d, err := client.AppsV1().Deployments(c.Namespace).Get(ctx, c.Deployment, metav1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("can't get deployment %v: %v", c.Deployment, err)
+		}
+		return fromLabelSelector(d.Spec.Selector)
+	case c.StatefulSet != "":
+		s, err := client.AppsV1().StatefulSets(c.Namespace).Get(ctx, c.StatefulSet, metav1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("can't get statefulset %v: %v", c.StatefulSet, err)
+		}
+		return fromLabelSelector(s.Spec.Selector)
+	case c.Service != "":
+		s, err := client.CoreV1().Services(c.Namespace).Get(ctx, c.Service, metav1.GetOptions{})
+		// Services used to be the app label of the pods
+		if apierrors.IsNotFound(err) {
+			return labels.SelectorFromSet(labels.Set{"app": c.Service}).String(), nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("can't get service %v: %v", c.Service, err)
+		}
+		if len(s.Spec.Selector) == 0 {
+			return "", fmt.Errorf("service %v has no selector", c.Service)
+		}
+		return labels.SelectorFromSet(s.Spec.Selector).String(), nil
+	}
+	return "", nil
+}
+
+func fromLabelSelector(s *metav1.LabelSelector) (string, error) {
+	selector, err := metav1.LabelSelectorAsSelector(s)
+	if err != nil {
+		return "", err
+	}
+	return selector.String(), nil
+}
+
+// ChoosePod returns the oldest of the running and ready pods, by name for the same age.
+func ChoosePod(pods []v1.Pod) (*v1.Pod, bool) {
+	var ready []v1.Pod
+	for _, pod := range pods {
+		if isReady(pod) {
+			ready = append(ready, pod)
+		}
+	}
+	if len(ready) == 0 {
+		return nil, false
+	}
+	sort.Slice(ready, func(i, j int) bool {
+		a, b := ready[i].CreationTimestamp, ready[j].CreationTimestamp
+		if !a.Equal(&b) {
+			return a.Before(&b)
+		}
+		return ready[i].Name < ready[j].Name
+	})
+	return &ready[0], true
+}
+
+// isReady if running, not terminating and with a Ready condition.
+func isReady(pod v1.Pod) bool {
+	if pod.Status.Phase != v1.PodRunning || pod.DeletionTimestamp != nil {
+		return false
+	}
+	for _, c := range pod.Status.Conditions {
+		if c.Type == v1.PodReady {
+			return c.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// ResolvePort returns the container port of the pod to forward a pod port to.
+// With a service, the pod port is a port of the service, forwarded to its target port like kubectl port-forward svc/...
+func ResolvePort(ctx context.Context, client kubernetes.Interface, c *configuration.Pod, pod *v1.Pod, podPort string) (int, error) {
+	port := intstr.Parse(podPort)
+	if c.Service != "" {
+		s, err := client.CoreV1().Services(c.Namespace).Get(ctx, c.Service, metav1.GetOptions{})
+		switch {
+		case apierrors.IsNotFound(err):
+			// The pods have the service as app label: the port is theirs
+		case err != nil:
+			return 0, fmt.Errorf("can't get service %v: %v", c.Service, err)
+		default:
+			sp, err := servicePort(s, podPort)
+			if err != nil {
+				return 0, err
+			}
+			port = sp.TargetPort
+			// The target port defaults to the port
+			if port.Type == intstr.Int && port.IntVal == 0 {
+				port = intstr.FromInt(int(sp.Port))
+			}
+		}
+	}
+	return containerPort(pod, c.Container, port)
+}
+
+// servicePort finds a port of the service by name or number, the only one if not specified.
+func servicePort(s *v1.Service, port string) (v1.ServicePort, error) {
+	if port == "" && len(s.Spec.Ports) == 1 {
+		return s.Spec.Ports[0], nil
+	}
+	for _, sp := range s.Spec.Ports {
+		if sp.Name == port || strconv.Itoa(int(sp.Port)) == port {
+			return sp, nil
+		}
+	}
+	return v1.ServicePort{}, fmt.Errorf("service %v has no port %q", s.Name, port)
+}
+
+// containerPort returns the port number, looking up a named port in the containers of the pod, or in the chosen one.
+func containerPort(pod *v1.Pod, container string, port intstr.IntOrString) (int, error) {
+	if port.Type == intstr.Int {
+		if port.IntVal <= 0 || port.IntVal > 65535 {
+			return 0, fmt.Errorf("invalid pod port %v", port.IntVal)
+		}
+		return int(port.IntVal), nil
+	}
+	if port.StrVal == "" {
+		return 0, fmt.Errorf("podPort required")
+	}
+	for _, c := range pod.Spec.Containers {
+		if container != "" && c.Name != container {
+			continue
+		}
+		for _, cp := range c.Ports {
+			if cp.Name == port.StrVal {
+				return int(cp.ContainerPort), nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("pod %v has no port named %v", pod.Name, port.StrVal)
+}
@@ -0,0 +1,15 @@
+package runner
+
+import (
+	"sync/atomic"
+
+	"github.com/AntoineToussaint/kommence/pkg/session"
+)
+
+// recorder saves the process groups started by the session, if any.
+var recorder atomic.Pointer[session.Recorder]
+
+// Record the process groups started from now on, so that they can be cleaned up if kommence dies.
+func Record(r *session.Recorder) {
+	recorder.Store(r)
+}
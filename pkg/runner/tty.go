@@ -0,0 +1,33 @@
+package runner
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/creack/pty"
+)
+
+// windowSize of the terminal running kommence, 80x24 if there is none.
+func windowSize() *pty.Winsize {
+	size, err := pty.GetsizeFull(os.Stdout)
+	if err != nil || size.Rows == 0 || size.Cols == 0 {
+		return &pty.Winsize{Rows: 24, Cols: 80}
+	}
+	return size
+}
+
+// resize the pseudo-terminal of a process when the window of kommence is resized, until done.
+func resize(tty *os.File, done chan struct{}) {
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer signal.Stop(winch)
+	for {
+		select {
+		case <-winch:
+			_ = pty.Setsize(tty, windowSize())
+		case <-done:
+			return
+		}
+	}
+}
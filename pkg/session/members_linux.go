@@ -0,0 +1,65 @@
+//go:build linux
+
+package session
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Members lists the processes of a group as name (pid N), from /proc.
+func Members(pgid int) []string {
+	stats, _ := filepath.Glob("/proc/[0-9]*/stat")
+	var pids []int
+	names := make(map[int]string)
+	for _, path := range stats {
+		pid, name, fields, ok := stat(path)
+		// Zombies are already dead
+		if !ok || len(fields) < 3 || fields[0] == "Z" || fields[2] != strconv.Itoa(pgid) {
+			continue
+		}
+		pids = append(pids, pid)
+		names[pid] = name
+	}
+	sort.Ints(pids)
+	var members []string
+	for _, pid := range pids {
+		members = append(members, fmt.Sprintf("%v (pid %v)", names[pid], pid))
+	}
+	return members
+}
+
+// startTime returns when a process started, in clock ticks since boot, or 0 if it's gone.
+func startTime(pid int) uint64 {
+	_, _, fields, ok := stat(fmt.Sprintf("/proc/%d/stat", pid))
+	// Field 22 of the stat file, the first field after comm being the third
+	if !ok || len(fields) < 20 || fields[0] == "Z" {
+		return 0
+	}
+	started, _ := strconv.ParseUint(fields[19], 10, 64)
+	return started
+}
+
+// stat reads a /proc/<pid>/stat file: pid (comm) state ppid pgrp ...
+// It returns the pid, the comm and the fields after it.
+func stat(path string) (int, string, []string, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, "", nil, false
+	}
+	// comm can contain spaces and parentheses
+	s := string(data)
+	open, closing := strings.IndexByte(s, '('), strings.LastIndexByte(s, ')')
+	if open < 0 || closing < open {
+		return 0, "", nil, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(s[:open]))
+	if err != nil {
+		return 0, "", nil, false
+	}
+	return pid, s[open+1 : closing], strings.Fields(s[closing+1:]), true
+}
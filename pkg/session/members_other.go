@@ -0,0 +1,13 @@
+//go:build !linux
+
+package session
+
+// Members is only implemented on Linux.
+func Members(pgid int) []string {
+	return nil
+}
+
+// startTime is only known on Linux.
+func startTime(pid int) uint64 {
+	return 0
+}
@@ -0,0 +1,154 @@
+// Package session records the process groups started by kommence, so that the next session
+// can stop them if kommence is killed before it stops them itself.
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+)
+
+// Group is a process group started for a task.
+type Group struct {
+	Task string
+	PGID int
+	// Started is when the group leader started, to tell it from a process reusing its PID. Zero if unknown.
+	Started uint64 `json:",omitempty"`
+}
+
+// State of a session, saved while it runs.
+type State struct {
+	// PID of kommence
+	PID int
+	// Started is when kommence started, to tell it from a process reusing its PID. Zero if unknown.
+	Started uint64 `json:",omitempty"`
+	Groups  []Group
+}
+
+// Recorder saves the running process groups to a state file. A nil Recorder records nothing.
+type Recorder struct {
+	mu      sync.Mutex
+	path    string
+	started uint64
+	groups  map[int]Group
+}
+
+func NewRecorder(path string) *Recorder {
+	return &Recorder{path: path, started: startTime(os.Getpid()), groups: make(map[int]Group)}
+}
+
+// Add a process group of a task.
+func (r *Recorder) Add(task string, pgid int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.groups[pgid] = Group{Task: task, PGID: pgid, Started: startTime(pgid)}
+	r.save()
+}
+
+// Remove a process group once it's gone.
+func (r *Recorder) Remove(pgid int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.groups, pgid)
+	r.save()
+}
+
+// Close removes the state file: the session stopped properly.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.groups = make(map[int]Group)
+	if err := os.Remove(r.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// save the state, replacing the file so that it's never partially written.
+func (r *Recorder) save() {
+	state := State{PID: os.Getpid(), Started: r.started}
+	for _, g := range r.groups {
+		state.Groups = append(state.Groups, g)
+	}
+	sort.Slice(state.Groups, func(i, j int) bool {
+		return state.Groups[i].PGID < state.Groups[j].PGID
+	})
+	data, err := json.Marshal(state)
+	if err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(r.path), filepath.Base(r.path))
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), r.path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+}
+
+// Leftovers returns the process groups of a previous session that are still running after kommence died.
+func Leftovers(path string) ([]Group, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("invalid session state %v: %v", path, err)
+	}
+	// The session is still running, unless its PID was reused
+	if state.PID != os.Getpid() && syscall.Kill(state.PID, 0) == nil && (state.Started == 0 || startTime(state.PID) == state.Started) {
+		return nil, nil
+	}
+	var leftovers []Group
+	for _, g := range state.Groups {
+		// Signal 0 only checks the group exists and can be signaled
+		if syscall.Kill(-g.PGID, 0) != nil {
+			continue
+		}
+		// The PGID was reused, or the leader is gone and the group can't be told apart
+		if g.Started != 0 && startTime(g.PGID) != g.Started {
+			continue
+		}
+		leftovers = append(leftovers, g)
+	}
+	return leftovers, nil
+}
+
+// Kill the process groups and forget the previous session.
+func Kill(path string, groups []Group) error {
+	for _, g := range groups {
+		if err := syscall.Kill(-g.PGID, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+			return fmt.Errorf("can't kill process group %v of %v: %v", g.PGID, g.Task, err)
+		}
+	}
+	return Forget(path)
+}
+
+// Forget the previous session, leaving its processes running.
+func Forget(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package session_test
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+
+	"github.com/AntoineToussaint/kommence/pkg/session"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeftovers(t *testing.T) {
+	state := filepath.Join(t.TempDir(), "kommence.state")
+	cmd := exec.Command("sleep", "5")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	assert.NoError(t, cmd.Start())
+	done := make(chan error)
+	go func() { done <- cmd.Wait() }()
+
+	// Nothing was recorded
+	leftovers, err := session.Leftovers(state)
+	assert.NoError(t, err)
+	assert.Empty(t, leftovers)
+
+	r := session.NewRecorder(state)
+	r.Add("api", cmd.Process.Pid)
+	r.Add("gone", 1<<22)
+	leftovers, err = session.Leftovers(state)
+	assert.NoError(t, err)
+	if assert.Len(t, leftovers, 1) {
+		assert.Equal(t, "api", leftovers[0].Task)
+		assert.Equal(t, cmd.Process.Pid, leftovers[0].PGID)
+	}
+	if runtime.GOOS == "linux" {
+		assert.Contains(t, session.Members(cmd.Process.Pid)[0], "sleep")
+		assert.NotZero(t, leftovers[0].Started)
+
+		// The PGID was reused by another process
+		reused := leftovers[0]
+		reused.Started++
+		data, err := json.Marshal(session.State{PID: 1 << 22, Groups: []session.Group{reused}})
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(state, data, 0644))
+		others, err := session.Leftovers(state)
+		assert.NoError(t, err)
+		assert.Empty(t, others)
+
+		// The PID of kommence was reused by another process
+		data, err = json.Marshal(session.State{PID: os.Getppid(), Started: 1, Groups: leftovers})
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(state, data, 0644))
+		others, err = session.Leftovers(state)
+		assert.NoError(t, err)
+		assert.Equal(t, leftovers, others)
+	}
+
+	assert.NoError(t, session.Kill(state, leftovers))
+	assert.Error(t, <-done)
+	_, err = os.Stat(state)
+	assert.True(t, os.IsNotExist(err))
+
+	// A session that stopped properly leaves nothing
+	r.Add("api", os.Getpid())
+	assert.NoError(t, r.Close())
+	leftovers, err = session.Leftovers(state)
+	assert.NoError(t, err)
+	assert.Empty(t, leftovers)
+}
@@ -0,0 +1,210 @@
+package watch
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	gitignore "github.com/monochromegane/go-gitignore"
+)
+
+// Matcher decides which paths are watched.
+//
+// Watch patterns are paths or globs where "**" matches any number of directories.
+// A path matches itself and everything below it. Ignore patterns without a "/"
+// match any file or directory with that name, like in a .gitignore file.
+type Matcher struct {
+	dir        string
+	roots      []string
+	watch      []string
+	ignore     []string
+	names      []string
+	gitignores []gitIgnore
+}
+
+type gitIgnore struct {
+	dir     string
+	matcher gitignore.IgnoreMatcher
+}
+
+// NewMatcher for patterns relative to dir. With useGitIgnore, the .gitignore files
+// of dir and its parents, up to the git repository root, are honoured.
+func NewMatcher(dir string, watch []string, ignore []string, useGitIgnore bool) (*Matcher, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	m := &Matcher{dir: dir}
+	for _, p := range watch {
+		m.watch = append(m.watch, absolute(dir, p))
+	}
+	m.roots = roots(m.watch)
+	// Never watch git internals
+	m.names = append(m.names, ".git")
+	for _, p := range ignore {
+		p = strings.TrimSuffix(p, "/")
+		if !strings.Contains(p, "/") {
+			m.names = append(m.names, p)
+			continue
+		}
+		m.ignore = append(m.ignore, absolute(dir, p))
+	}
+	if useGitIgnore {
+		m.gitignores = loadGitIgnores(dir)
+	}
+	return m, nil
+}
+
+// Roots are the paths to watch recursively: the part of each pattern before the first wildcard.
+func (m *Matcher) Roots() []string {
+	return m.roots
+}
+
+func roots(patterns []string) []string {
+	var roots []string
+	for _, p := range patterns {
+		segments := strings.Split(p, "/")
+		for i, s := range segments {
+			if hasMeta(s) {
+				segments = segments[:i]
+				break
+			}
+		}
+		root := filepath.FromSlash(strings.Join(segments, "/"))
+		if root == "" {
+			root = "/"
+		}
+		roots = append(roots, root)
+	}
+	return roots
+}
+
+// Watched returns true if a file matches a watch pattern and isn't ignored.
+func (m *Matcher) Watched(p string, isDir bool) bool {
+	if m.Ignored(p, isDir) {
+		return false
+	}
+	p = filepath.ToSlash(p)
+	for _, pattern := range m.watch {
+		if matchPrefix(pattern, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Ignored returns true if the path or one of its parents is ignored.
+func (m *Matcher) Ignored(p string, isDir bool) bool {
+	slashed := filepath.ToSlash(p)
+	for _, segment := range strings.Split(filepath.ToSlash(m.relative(p)), "/") {
+		for _, name := range m.names {
+			if ok, _ := path.Match(name, segment); ok {
+				return true
+			}
+		}
+	}
+	for _, pattern := range m.ignore {
+		if matchPrefix(pattern, slashed) {
+			return true
+		}
+	}
+	for _, g := range m.gitignores {
+		rel, err := filepath.Rel(g.dir, p)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		// Check the path and all its parents: an ignored directory ignores everything below
+		current, dir := p, isDir
+		for current != g.dir && strings.HasPrefix(current, g.dir) {
+			if g.matcher.Match(current, dir) {
+				return true
+			}
+			current, dir = filepath.Dir(current), true
+		}
+	}
+	return false
+}
+
+// relative returns p relative to the watch root or the directory containing it.
+func (m *Matcher) relative(p string) string {
+	for _, base := range append([]string{m.dir}, m.roots...) {
+		if rel, err := filepath.Rel(base, p); err == nil && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+	}
+	return filepath.Base(p)
+}
+
+// Match a slash separated name against a glob pattern where "**" matches any number of directories.
+func Match(pattern string, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern []string, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// matchPrefix matches the name or one of its parents.
+func matchPrefix(pattern string, name string) bool {
+	for {
+		if Match(pattern, name) {
+			return true
+		}
+		parent := path.Dir(name)
+		if parent == name {
+			return false
+		}
+		name = parent
+	}
+}
+
+func hasMeta(s string) bool {
+	return strings.ContainsAny(s, `*?[\`)
+}
+
+func absolute(dir string, p string) string {
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(dir, p)
+	}
+	return filepath.ToSlash(filepath.Clean(p))
+}
+
+// loadGitIgnores from dir up to the root of the git repository. Outside a repository, only dir is used.
+func loadGitIgnores(dir string) []gitIgnore {
+	var gitignores []gitIgnore
+	for current := dir; ; {
+		if g, err := gitignore.NewGitIgnore(filepath.Join(current, ".gitignore")); err == nil {
+			gitignores = append(gitignores, gitIgnore{dir: current, matcher: g})
+		}
+		if _, err := os.Stat(filepath.Join(current, ".git")); err == nil {
+			return gitignores
+		}
+		parent := filepath.Dir(current)
+		if parent == current {
+			break
+		}
+		current = parent
+	}
+	if len(gitignores) > 0 && gitignores[0].dir == dir {
+		return gitignores[:1]
+	}
+	return nil
+}
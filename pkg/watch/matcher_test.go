@@ -0,0 +1,48 @@
+package watch_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AntoineToussaint/kommence/pkg/watch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatch(t *testing.T) {
+	assert.True(t, watch.Match("**/*.go", "main.go"))
+	assert.True(t, watch.Match("**/*.go", "pkg/runner/runner.go"))
+	assert.True(t, watch.Match("pkg/**/*.go", "pkg/runner/runner.go"))
+	assert.True(t, watch.Match("pkg/**", "pkg/runner/runner.go"))
+	assert.False(t, watch.Match("**/*.go", "pkg/runner/runner.yml"))
+	assert.False(t, watch.Match("pkg/*.go", "pkg/runner/runner.go"))
+}
+
+func TestMatcher(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, ".gitignore"), []byte("build/\n*.log\n"), 0644))
+
+	m, err := watch.NewMatcher(dir, []string{"**/*.go", "config.yml", "assets"}, []string{"node_modules", "gen/**"}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{dir, filepath.Join(dir, "config.yml"), filepath.Join(dir, "assets")}, m.Roots())
+
+	watched := func(p string) bool {
+		return m.Watched(filepath.Join(dir, p), false)
+	}
+	assert.True(t, watched("main.go"))
+	assert.True(t, watched("pkg/runner.go"))
+	assert.True(t, watched("config.yml"))
+	assert.True(t, watched("assets/logo.png"))
+	assert.False(t, watched("README.md"))
+	// Ignored
+	assert.False(t, watched("node_modules/dep/index.go"))
+	assert.False(t, watched("gen/api.go"))
+	assert.False(t, watched(".git/hooks/hook.go"))
+	// From .gitignore
+	assert.False(t, watched("build/out.go"))
+	assert.False(t, watched("assets/debug.log"))
+
+	m, err = watch.NewMatcher(dir, []string{"**/*.go"}, nil, false)
+	assert.NoError(t, err)
+	assert.True(t, m.Watched(filepath.Join(dir, "build/out.go"), false))
+}
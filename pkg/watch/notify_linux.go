@@ -0,0 +1,364 @@
+//go:build linux
+
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const notifyMask = unix.IN_CREATE | unix.IN_MODIFY | unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO
+
+// v9fsMagic is used by WSL and some VM shared folders.
+const v9fsMagic = 0x01021997
+
+// remoteFileSystems don't send notifications for changes made on other machines.
+var remoteFileSystems = map[int64]bool{
+	unix.NFS_SUPER_MAGIC:  true,
+	unix.SMB_SUPER_MAGIC:  true,
+	unix.SMB2_SUPER_MAGIC: true,
+	unix.CIFS_SUPER_MAGIC: true,
+	unix.CODA_SUPER_MAGIC: true,
+	unix.AFS_SUPER_MAGIC:  true,
+	unix.CEPH_SUPER_MAGIC: true,
+	unix.FUSE_SUPER_MAGIC: true,
+	v9fsMagic:             true,
+}
+
+// errNotifyUnsupported means polling is expected: no need to warn.
+var errNotifyUnsupported = errors.New("file system notifications not supported")
+
+// notifier is the inotify instance shared by all the watchers of the process.
+type notifier struct {
+	fd   int
+	file *os.File
+
+	mu    sync.Mutex
+	buf   []byte
+	paths map[int]string
+	wds   map[string]int
+	refs  map[string]int
+	subs  map[*subscription]bool
+}
+
+var shared struct {
+	once     sync.Once
+	notifier *notifier
+	err      error
+}
+
+func sharedNotifier() (*notifier, error) {
+	shared.once.Do(func() {
+		fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+		if err != nil {
+			shared.err = fmt.Errorf("can't initialize inotify: %v", err)
+			return
+		}
+		n := &notifier{
+			fd:    fd,
+			file:  os.NewFile(uintptr(fd), "inotify"),
+			buf:   make([]byte, 64*1024),
+			paths: make(map[int]string),
+			wds:   make(map[string]int),
+			refs:  make(map[string]int),
+			subs:  make(map[*subscription]bool),
+		}
+		go n.read()
+		shared.notifier = n
+	})
+	return shared.notifier, shared.err
+}
+
+// subscription is the notifyBackend of one Watcher.
+type subscription struct {
+	n         *notifier
+	matcher   *Matcher
+	dirRoots  []string
+	fileRoots []string
+	dirs      map[string]bool
+	events    chan string
+	errors    chan error
+	// overflows when events were dropped
+	overflows chan struct{}
+}
+
+func newNotifyBackend(matcher *Matcher, roots []string) (backend, error) {
+	for _, root := range roots {
+		var st unix.Statfs_t
+		if err := unix.Statfs(root, &st); err == nil && remoteFileSystems[int64(st.Type)] {
+			return nil, errNotifyUnsupported
+		}
+	}
+	n, err := sharedNotifier()
+	if err != nil {
+		return nil, err
+	}
+	s := &subscription{
+		n:       n,
+		matcher: matcher,
+		dirs:    make(map[string]bool),
+		// Buffered so that a slow watcher doesn't block the others
+		events:    make(chan string, 1024),
+		errors:    make(chan error, 1),
+		overflows: make(chan struct{}, 1),
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	// Deliver what happened before to the current watchers only
+	if err := n.drain(); err != nil {
+		return nil, err
+	}
+	for _, root := range roots {
+		fi, err := os.Stat(root)
+		if err != nil {
+			continue
+		}
+		if !fi.IsDir() {
+			s.fileRoots = append(s.fileRoots, root)
+			err = n.add(s, filepath.Dir(root))
+		} else {
+			s.dirRoots = append(s.dirRoots, root)
+			err = n.addRecursive(s, root, nil)
+		}
+		if err != nil {
+			n.remove(s)
+			return nil, err
+		}
+	}
+	n.subs[s] = true
+	return s, nil
+}
+
+func (s *subscription) Start() {}
+
+func (s *subscription) Events() <-chan string {
+	return s.events
+}
+
+func (s *subscription) Errors() <-chan error {
+	return s.errors
+}
+
+func (s *subscription) Overflows() <-chan struct{} {
+	return s.overflows
+}
+
+func (s *subscription) Close() {
+	s.n.mu.Lock()
+	defer s.n.mu.Unlock()
+	s.n.remove(s)
+}
+
+// wants returns true if the path is below a root of the subscription.
+func (s *subscription) wants(p string, isDir bool) bool {
+	for _, root := range s.dirRoots {
+		if within(root, p) {
+			return true
+		}
+	}
+	if isDir {
+		return false
+	}
+	for _, root := range s.fileRoots {
+		if root == p {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *subscription) send(p string) {
+	if !s.matcher.Watched(p, false) {
+		return
+	}
+	select {
+	case s.events <- p:
+	default:
+		// Too many changes at once: they are reported as a change of the roots
+		s.overflow()
+	}
+}
+
+func (s *subscription) overflow() {
+	select {
+	case s.overflows <- struct{}{}:
+	default:
+	}
+}
+
+func (s *subscription) fail(err error) {
+	select {
+	case s.errors <- err:
+	default:
+	}
+}
+
+// add a watch on a directory for a subscription. The lock must be held.
+func (n *notifier) add(s *subscription, dir string) error {
+	if s.dirs[dir] {
+		return nil
+	}
+	if _, ok := n.wds[dir]; !ok {
+		wd, err := unix.InotifyAddWatch(n.fd, dir, notifyMask)
+		if err != nil {
+			if err == unix.ENOSPC {
+				return fmt.Errorf("inotify watch limit reached, increase fs.inotify.max_user_watches")
+			}
+			return fmt.Errorf("can't watch %v: %v", dir, err)
+		}
+		n.wds[dir] = wd
+		n.paths[wd] = dir
+	}
+	n.refs[dir]++
+	s.dirs[dir] = true
+	return nil
+}
+
+// addRecursive watches dir and its sub-directories, calling found for the files. The lock must be held.
+func (n *notifier) addRecursive(s *subscription, dir string, found func(p string)) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Gone already
+			return nil
+		}
+		if !d.IsDir() {
+			if found != nil {
+				found(p)
+			}
+			return nil
+		}
+		if s.matcher.Ignored(p, true) {
+			return filepath.SkipDir
+		}
+		return n.add(s, p)
+	})
+}
+
+// remove all the watches of a subscription. The lock must be held.
+func (n *notifier) remove(s *subscription) {
+	delete(n.subs, s)
+	for dir := range s.dirs {
+		n.refs[dir]--
+		if n.refs[dir] > 0 {
+			continue
+		}
+		if wd, ok := n.wds[dir]; ok {
+			_, _ = unix.InotifyRmWatch(n.fd, uint32(wd))
+			n.forget(wd)
+		}
+	}
+	s.dirs = make(map[string]bool)
+}
+
+func (n *notifier) forget(wd int) {
+	dir, ok := n.paths[wd]
+	if !ok {
+		return
+	}
+	delete(n.paths, wd)
+	delete(n.wds, dir)
+	delete(n.refs, dir)
+	for s := range n.subs {
+		delete(s.dirs, dir)
+	}
+}
+
+func (n *notifier) read() {
+	conn, err := n.file.SyscallConn()
+	if err == nil {
+		// Read while holding the lock so that new subscriptions never get older events
+		readErr := conn.Read(func(uintptr) bool {
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			err = n.drain()
+			return err != nil
+		})
+		if err == nil {
+			err = readErr
+		}
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for s := range n.subs {
+		s.fail(fmt.Errorf("inotify stopped: %v", err))
+	}
+}
+
+// drain handles the queued events without blocking. The lock must be held.
+func (n *notifier) drain() error {
+	for {
+		size, err := unix.Read(n.fd, n.buf)
+		if err == unix.EINTR {
+			continue
+		}
+		if err == unix.EAGAIN {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for offset := 0; offset+unix.SizeofInotifyEvent <= size; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&n.buf[offset]))
+			name := n.buf[offset+unix.SizeofInotifyEvent : offset+unix.SizeofInotifyEvent+int(event.Len)]
+			offset += unix.SizeofInotifyEvent + int(event.Len)
+			n.handle(event, strings.TrimRight(string(name), "\x00"))
+		}
+	}
+}
+
+// handle an event. The lock must be held.
+func (n *notifier) handle(event *unix.InotifyEvent, name string) {
+	if event.Mask&unix.IN_Q_OVERFLOW != 0 {
+		for s := range n.subs {
+			s.overflow()
+			s.fail(fmt.Errorf("too many file system events, some were dropped"))
+		}
+		return
+	}
+	if event.Mask&unix.IN_IGNORED != 0 {
+		n.forget(int(event.Wd))
+		return
+	}
+	dir, ok := n.paths[int(event.Wd)]
+	if !ok {
+		return
+	}
+	p := filepath.Join(dir, name)
+	isDir := event.Mask&unix.IN_ISDIR != 0
+	// A directory moved away keeps its watches: drop them, they are added back if it lands in a watched directory
+	if isDir && event.Mask&unix.IN_MOVED_FROM != 0 {
+		for d, wd := range n.wds {
+			if within(p, d) {
+				_, _ = unix.InotifyRmWatch(n.fd, uint32(wd))
+				n.forget(wd)
+			}
+		}
+	}
+	for s := range n.subs {
+		if !s.wants(p, isDir) {
+			continue
+		}
+		if !isDir {
+			s.send(p)
+			continue
+		}
+		// A new directory: watch it, files may have been created before the watch
+		if event.Mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 {
+			if err := n.addRecursive(s, p, s.send); err != nil {
+				s.fail(err)
+			}
+		}
+	}
+}
+
+func within(root string, p string) bool {
+	return p == root || strings.HasPrefix(p, root+string(filepath.Separator))
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package watch
+
+import "errors"
+
+// errNotifyUnsupported means polling is expected: no need to warn.
+var errNotifyUnsupported = errors.New("file system notifications not supported")
+
+// newNotifyBackend is only implemented on Linux.
+func newNotifyBackend(matcher *Matcher, roots []string) (backend, error) {
+	return nil, errNotifyUnsupported
+}
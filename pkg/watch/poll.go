@@ -0,0 +1,109 @@
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/radovskyb/watcher"
+)
+
+const pollInterval = 100 * time.Millisecond
+
+// pollBackend lists the watched files every pollInterval, for file systems without notifications.
+type pollBackend struct {
+	poller *watcher.Watcher
+	events chan string
+	errors chan error
+	done   chan struct{}
+}
+
+func newPollBackend(matcher *Matcher, roots []string) (*pollBackend, error) {
+	b := &pollBackend{
+		poller: watcher.New(),
+		events: make(chan string),
+		errors: make(chan error),
+		done:   make(chan struct{}),
+	}
+	b.poller.FilterOps(watcher.Create, watcher.Write, watcher.Remove, watcher.Rename, watcher.Move)
+	b.poller.AddFilterHook(func(info os.FileInfo, p string) error {
+		if info.IsDir() {
+			if matcher.Ignored(p, true) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !matcher.Watched(p, false) {
+			return watcher.ErrSkip
+		}
+		return nil
+	})
+	for _, root := range roots {
+		if err := b.poller.AddRecursive(root); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func (b *pollBackend) Start() {
+	go func() {
+		if err := b.poller.Start(pollInterval); err != nil {
+			b.sendError(err)
+		}
+	}()
+	go b.run()
+}
+
+func (b *pollBackend) Events() <-chan string {
+	return b.events
+}
+
+func (b *pollBackend) Errors() <-chan error {
+	return b.errors
+}
+
+// Overflows never happen: the poller waits for the events to be read.
+func (b *pollBackend) Overflows() <-chan struct{} {
+	return nil
+}
+
+func (b *pollBackend) Close() {
+	close(b.done)
+	b.poller.Close()
+}
+
+func (b *pollBackend) run() {
+	// Keep draining the poller so that it can be closed
+	for {
+		select {
+		case event := <-b.poller.Event:
+			if event.FileInfo != nil && event.IsDir() {
+				continue
+			}
+			for _, p := range []string{event.Path, event.OldPath} {
+				if p != "" {
+					b.sendEvent(p)
+				}
+			}
+		case err := <-b.poller.Error:
+			b.sendError(err)
+		case <-b.poller.Closed:
+			return
+		}
+	}
+}
+
+func (b *pollBackend) sendEvent(p string) {
+	select {
+	case b.events <- p:
+	case <-b.done:
+	}
+}
+
+func (b *pollBackend) sendError(err error) {
+	select {
+	case b.errors <- err:
+	case <-b.done:
+	}
+}
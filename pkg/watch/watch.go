@@ -0,0 +1,155 @@
+package watch
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// DefaultDebounce is how long to wait for the file system to be quiet before reporting changes.
+const DefaultDebounce = 200 * time.Millisecond
+
+// Config of a Watcher.
+type Config struct {
+	// Dir is the directory patterns are relative to
+	Dir       string
+	Watch     []string
+	Ignore    []string
+	GitIgnore bool
+	Debounce  time.Duration
+}
+
+// backend reports the paths that changed below the roots of a Matcher.
+type backend interface {
+	Start()
+	Events() <-chan string
+	Errors() <-chan error
+	// Overflows when events were dropped: anything below the roots may have changed
+	Overflows() <-chan struct{}
+	Close()
+}
+
+// Watcher reports the files that were created, written, removed or renamed.
+// Changes are debounced: a burst of events, like a git checkout, is reported once.
+//
+// File system notifications are used when available, with one watch service shared by all
+// watchers of the process. Otherwise, the file system is polled.
+type Watcher struct {
+	// Changes are the changed files of a burst, sorted, or the watched roots when there were too many to tell
+	Changes chan []string
+	// Errors are not fatal: the watcher keeps going
+	Errors chan error
+
+	matcher  *Matcher
+	roots    []string
+	debounce time.Duration
+	backend  backend
+	done     chan struct{}
+}
+
+// New creates a Watcher. The warnings are about paths that can't be watched.
+func New(cfg Config) (*Watcher, []string, error) {
+	matcher, err := NewMatcher(cfg.Dir, cfg.Watch, cfg.Ignore, cfg.GitIgnore)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cfg.Debounce <= 0 {
+		cfg.Debounce = DefaultDebounce
+	}
+	var warnings []string
+	var roots []string
+	for _, root := range matcher.Roots() {
+		if _, err := os.Stat(root); err != nil {
+			warnings = append(warnings, fmt.Sprintf("can't watch %v: %v", root, err))
+			continue
+		}
+		roots = append(roots, root)
+	}
+	var b backend
+	b, err = newNotifyBackend(matcher, roots)
+	if err != nil {
+		if err != errNotifyUnsupported {
+			warnings = append(warnings, fmt.Sprintf("falling back to polling: %v", err))
+		}
+		b, err = newPollBackend(matcher, roots)
+		if err != nil {
+			return nil, warnings, err
+		}
+	}
+	return &Watcher{
+		Changes:  make(chan []string),
+		Errors:   make(chan error),
+		matcher:  matcher,
+		roots:    roots,
+		debounce: cfg.Debounce,
+		backend:  b,
+		done:     make(chan struct{}),
+	}, warnings, nil
+}
+
+// Start watching.
+func (w *Watcher) Start() {
+	w.backend.Start()
+	go w.run()
+}
+
+// Close stops watching.
+func (w *Watcher) Close() {
+	select {
+	case <-w.done:
+		return
+	default:
+	}
+	close(w.done)
+	w.backend.Close()
+}
+
+func (w *Watcher) run() {
+	pending := make(map[string]bool)
+	var timer *time.Timer
+	var fire <-chan time.Time
+	debounce := func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		timer = time.NewTimer(w.debounce)
+		fire = timer.C
+	}
+	for {
+		select {
+		case p := <-w.backend.Events():
+			if !w.matcher.Watched(p, false) {
+				continue
+			}
+			pending[p] = true
+			debounce()
+		case <-w.backend.Overflows():
+			for _, root := range w.roots {
+				pending[root] = true
+			}
+			debounce()
+		case <-fire:
+			fire = nil
+			var changes []string
+			for p := range pending {
+				changes = append(changes, p)
+			}
+			sort.Strings(changes)
+			pending = make(map[string]bool)
+			select {
+			case w.Changes <- changes:
+			case <-w.done:
+				return
+			}
+		case err := <-w.backend.Errors():
+			select {
+			case w.Errors <- err:
+			case <-w.done:
+				return
+			}
+		case <-w.done:
+			return
+		}
+	}
+}
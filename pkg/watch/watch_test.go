@@ -0,0 +1,97 @@
+package watch_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AntoineToussaint/kommence/pkg/watch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatcher(t *testing.T) {
+	dir := t.TempDir()
+	w, warnings, err := watch.New(watch.Config{Dir: dir, Watch: []string{"**/*.go", "missing"}, Debounce: 300 * time.Millisecond})
+	assert.NoError(t, err)
+	assert.Len(t, warnings, 1)
+	w.Start()
+	defer w.Close()
+	time.Sleep(200 * time.Millisecond)
+
+	// A burst of changes is reported once
+	for i := 0; i < 20; i++ {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%02d.go", i)), []byte("package main"), 0644))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%02d.txt", i)), []byte("ignored"), 0644))
+	}
+	changes := <-w.Changes
+	assert.Len(t, changes, 20)
+	assert.Equal(t, filepath.Join(dir, "file00.go"), changes[0])
+	select {
+	case changes = <-w.Changes:
+		t.Fatalf("unexpected changes: %v", changes)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	// Removals count
+	assert.NoError(t, os.Remove(filepath.Join(dir, "file00.go")))
+	assert.Equal(t, []string{filepath.Join(dir, "file00.go")}, <-w.Changes)
+}
+
+func TestWatcherNewDirectory(t *testing.T) {
+	dir := t.TempDir()
+	// Watchers of the same tree share the file system notifications
+	var watchers []*watch.Watcher
+	for i := 0; i < 2; i++ {
+		w, _, err := watch.New(watch.Config{Dir: dir, Watch: []string{"."}, Ignore: []string{"tmp"}, Debounce: 100 * time.Millisecond})
+		assert.NoError(t, err)
+		w.Start()
+		defer w.Close()
+		watchers = append(watchers, w)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "pkg", "sub"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "pkg", "sub", "main.go"), []byte("package main"), 0644))
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "tmp"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "tmp", "cache"), []byte("ignored"), 0644))
+	for _, w := range watchers {
+		select {
+		case changes := <-w.Changes:
+			assert.Equal(t, []string{filepath.Join(dir, "pkg", "sub", "main.go")}, changes)
+		case <-time.After(2 * time.Second):
+			t.Fatal("no changes")
+		}
+	}
+}
+
+func TestWatcherOverflow(t *testing.T) {
+	dir := t.TempDir()
+	w, _, err := watch.New(watch.Config{Dir: dir, Watch: []string{"**/*.go"}, Debounce: 100 * time.Millisecond})
+	assert.NoError(t, err)
+	w.Start()
+	defer w.Close()
+	time.Sleep(200 * time.Millisecond)
+
+	// Ignored files don't count
+	for i := 0; i < 2000; i++ {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%04d.txt", i)), []byte("ignored"), 0644))
+	}
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main"), 0644))
+	assert.Equal(t, []string{filepath.Join(dir, "main.go")}, <-w.Changes)
+
+	// Too many changes while busy are reported as a change of the roots
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main"), 0644))
+	time.Sleep(300 * time.Millisecond)
+	for i := 0; i < 2000; i++ {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%04d.go", i)), []byte("package main"), 0644))
+	}
+	assert.Equal(t, []string{filepath.Join(dir, "main.go")}, <-w.Changes)
+	select {
+	case changes := <-w.Changes:
+		assert.Contains(t, changes, dir)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no changes")
+	}
+}